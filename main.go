@@ -1,21 +1,26 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"math"
 	"os"
+	"strconv"
 	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
+	"github.com/docker/go-units"
 	"github.com/mousany/gophinator/runtime"
+	"github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
+	"golang.org/x/sys/unix"
 )
 
 func newRuntime(c *cli.Context) (*runtime.Runtime, error) {
-	if c.Bool("debug") {
-		logrus.SetLevel(logrus.DebugLevel)
-	}
-
-	if c.NArg() < 1 {
+	if c.NArg() < 1 && !c.IsSet("image") && !c.IsSet("root") {
 		fmt.Fprintln(os.Stderr, "Incorrect Usage: command needs an argument: run")
 		fmt.Fprintln(os.Stderr)
 		cli.ShowSubcommandHelpAndExit(c, 1)
@@ -32,18 +37,446 @@ func newRuntime(c *cli.Context) (*runtime.Runtime, error) {
 
 	volumes := []runtime.VolumePair{}
 	for _, v := range c.StringSlice("volume") {
-		chunks := strings.Split(v, ":")
-		if len(chunks) != 2 {
-			fmt.Fprintf(os.Stderr, "Incorrect Usage: volume must be in the form 'source:target': %s", v)
+		volume, err := runtime.ParseVolume(v)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Incorrect Usage: %s", err)
+			fmt.Fprintln(os.Stderr)
+			cli.ShowSubcommandHelpAndExit(c, 1)
+		}
+		volumes = append(volumes, volume)
+	}
+
+	mounts := []runtime.Mount{}
+	for _, m := range c.StringSlice("mount") {
+		mount, err := runtime.ParseMount(m)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Incorrect Usage: %s", err)
+			fmt.Fprintln(os.Stderr)
+			cli.ShowSubcommandHelpAndExit(c, 1)
+		}
+		mounts = append(mounts, mount)
+	}
+	for _, t := range c.StringSlice("tmpfs") {
+		mount, err := runtime.ParseTmpfs(t)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Incorrect Usage: %s", err)
 			fmt.Fprintln(os.Stderr)
 			cli.ShowSubcommandHelpAndExit(c, 1)
 		}
-		source := chunks[0]
-		target := strings.TrimPrefix(chunks[1], "/")
-		volumes = append(volumes, runtime.VolumePair{Source: source, Target: target})
+		mounts = append(mounts, mount)
+	}
+
+	devices := []runtime.Device{}
+	for _, d := range c.StringSlice("device") {
+		device, err := runtime.ParseDevice(d)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Incorrect Usage: %s", err)
+			fmt.Fprintln(os.Stderr)
+			cli.ShowSubcommandHelpAndExit(c, 1)
+		}
+		devices = append(devices, device)
+	}
+
+	ports := []runtime.PortMapping{}
+	for _, p := range c.StringSlice("publish") {
+		mapping, err := runtime.ParsePortMapping(p)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Incorrect Usage: %s", err)
+			fmt.Fprintln(os.Stderr)
+			cli.ShowSubcommandHelpAndExit(c, 1)
+		}
+		ports = append(ports, mapping)
+	}
+
+	seccomp := ""
+	for _, opt := range c.StringSlice("security-opt") {
+		key, value, _ := strings.Cut(opt, "=")
+		if key != "seccomp" || value == "" {
+			fmt.Fprintf(os.Stderr, "Incorrect Usage: unknown security option %q", opt)
+			fmt.Fprintln(os.Stderr)
+			cli.ShowSubcommandHelpAndExit(c, 1)
+		}
+		seccomp = value
+	}
+
+	resources, err := parseResources(c)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Incorrect Usage: %s", err)
+		fmt.Fprintln(os.Stderr)
+		cli.ShowSubcommandHelpAndExit(c, 1)
+	}
+
+	user := c.String("user")
+	if user == "" && c.IsSet("uid") {
+		user = strconv.Itoa(c.Int("uid"))
+	}
+	var entrypoint []string
+	if c.IsSet("entrypoint") {
+		entrypoint = []string{}
+		if e := c.String("entrypoint"); e != "" {
+			entrypoint = append(entrypoint, e)
+		}
+	}
+
+	return runtime.New(c.Args().First(), args, &runtime.Options{
+		UID:           c.Int("uid"),
+		User:          user,
+		Entrypoint:    entrypoint,
+		Env:           c.StringSlice("env"),
+		Workdir:       c.String("workdir"),
+		Root:          c.String("root"),
+		Image:         c.String("image"),
+		Volumes:       volumes,
+		Mounts:        mounts,
+		NoProc:        c.Bool("no-proc"),
+		NoSysfs:       c.Bool("no-sysfs"),
+		NoDevpts:      c.Bool("no-devpts"),
+		NoShm:         c.Bool("no-shm"),
+		Devices:       devices,
+		Seccomp:       seccomp,
+		SeccompLegacy: c.Bool("seccomp-legacy"),
+		SeccompLearn:  c.String("seccomp-learn"),
+		Resources:     *resources,
+		Init:          c.Bool("init"),
+		Network:       c.String("network"),
+		Ports:         ports,
+		PublishAll:    c.Bool("publish-all"),
+		Remove:        c.Bool("rm"),
+	})
+}
+
+func parseResources(c *cli.Context) (*runtime.Resources, error) {
+	resources := &runtime.Resources{
+		CPUs:        c.Float64("cpus"),
+		CPUShares:   c.Uint64("cpu-shares"),
+		CpusetCPUs:  c.String("cpuset-cpus"),
+		PidsLimit:   c.Int64("pids-limit"),
+		BlkIOWeight: uint16(c.Uint("blkio-weight")),
+	}
+	if c.Uint("blkio-weight") > math.MaxUint16 {
+		return nil, fmt.Errorf("blkio weight is out of range: %d", c.Uint("blkio-weight"))
+	}
+
+	var err error
+	if c.IsSet("memory") {
+		resources.Memory, err = units.RAMInBytes(c.String("memory"))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c.IsSet("memory-swap") {
+		swap := c.String("memory-swap")
+		if swap == "-1" {
+			resources.MemorySwap = -1
+		} else {
+			resources.MemorySwap, err = units.RAMInBytes(swap)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	err = resources.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return resources, nil
+}
+
+func detach(con *runtime.Runtime) error {
+	err := con.Create()
+	if err != nil {
+		return err
+	}
+	err = runtime.Start(con.ID())
+	if err != nil {
+		runtime.Delete(con.ID(), true)
+		return err
+	}
+	fmt.Println(con.ID())
+
+	return nil
+}
+
+func containerID(c *cli.Context) string {
+	if c.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "Incorrect Usage: command needs an argument: container id")
+		fmt.Fprintln(os.Stderr)
+		cli.ShowSubcommandHelpAndExit(c, 1)
+	}
+
+	return c.Args().First()
+}
+
+func parseFilters(c *cli.Context) ([][2]string, error) {
+	keys := map[string]bool{"id": true, "status": true, "exited": true}
+
+	filters := [][2]string{}
+	for _, f := range c.StringSlice("filter") {
+		key, value, ok := strings.Cut(f, "=")
+		if !ok || !keys[key] {
+			return nil, fmt.Errorf("filter must be in the form 'id|status|exited=value': %s", f)
+		}
+		filters = append(filters, [2]string{key, value})
+	}
+
+	return filters, nil
+}
+
+func matchFilters(info *runtime.Info, filters [][2]string) bool {
+	for _, f := range filters {
+		switch f[0] {
+		case "id":
+			if !strings.HasPrefix(info.ID, f[1]) {
+				return false
+			}
+		case "status":
+			if string(info.Status) != f[1] {
+				return false
+			}
+		case "exited":
+			if info.Status != specs.StateStopped || strconv.Itoa(info.ExitCode) != f[1] {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func printContainers(infos []*runtime.Info) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "CONTAINER ID\tCOMMAND\tSTATUS\tEXIT CODE\tCREATED")
+	for _, info := range infos {
+		command := strings.Join(info.Config.Process.Args, " ")
+		if len(command) > 30 {
+			command = command[:27] + "..."
+		}
+		code := ""
+		if info.Status == specs.StateStopped {
+			code = strconv.Itoa(info.ExitCode)
+		}
+		age := units.HumanDuration(time.Since(info.Created)) + " ago"
+		fmt.Fprintf(w, "%s\t%q\t%s\t%s\t%s\n", info.ID, command, info.Status, code, age)
+	}
+	w.Flush()
+}
+
+func imageRef(c *cli.Context) string {
+	if c.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "Incorrect Usage: command needs an argument: image")
+		fmt.Fprintln(os.Stderr)
+		cli.ShowSubcommandHelpAndExit(c, 1)
 	}
 
-	return runtime.New(c.Args().First(), args, c.Int("uid"), c.String("root"), volumes)
+	return c.Args().First()
+}
+
+func listImages(c *cli.Context) error {
+	if c.String("format") != "table" && c.String("format") != "json" {
+		fmt.Fprintf(os.Stderr, "Incorrect Usage: format must be table or json: %s", c.String("format"))
+		fmt.Fprintln(os.Stderr)
+		cli.ShowSubcommandHelpAndExit(c, 1)
+	}
+
+	images, err := runtime.Images()
+	if err != nil {
+		logrus.Errorf("Fail to list images: %s", err)
+		return err
+	}
+
+	if c.String("format") == "json" {
+		data, err := json.MarshalIndent(images, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	printImages(images)
+
+	return nil
+}
+
+func printImages(images []*runtime.Image) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "REPOSITORY\tTAG\tIMAGE ID\tCREATED\tSIZE")
+	for _, img := range images {
+		names := img.Names
+		if len(names) == 0 {
+			names = []string{"<none>:<none>"}
+		}
+		age := units.HumanDuration(time.Since(img.Created)) + " ago"
+		for _, name := range names {
+			repo, tag := name, "<none>"
+			if sep := strings.LastIndex(name, ":"); sep > strings.LastIndex(name, "/") {
+				repo, tag = name[:sep], name[sep+1:]
+			}
+			fmt.Fprintf(w, "%s\t%s\t%.12s\t%s\t%s\n", repo, tag, img.ID.Encoded(), age, units.HumanSize(float64(img.Size)))
+		}
+	}
+	w.Flush()
+}
+
+func imageListFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:  "format",
+			Value: "table",
+			Usage: "print the images as a table or as json with `FORMAT`",
+		},
+	}
+}
+
+func parseSignal(s string) (syscall.Signal, error) {
+	num, err := strconv.Atoi(s)
+	if err == nil {
+		return syscall.Signal(num), nil
+	}
+
+	name := strings.ToUpper(s)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	sig := unix.SignalNum(name)
+	if sig == 0 {
+		return 0, fmt.Errorf("unknown signal: %s", s)
+	}
+
+	return sig, nil
+}
+
+func containerFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.IntFlag{
+			Name:    "uid",
+			Aliases: []string{"u"},
+			Usage:   "create the container with the specified `UID`",
+		},
+		&cli.StringFlag{
+			Name:  "user",
+			Usage: "run the command as `USER[:GROUP]`, given by name or ID, overriding the image",
+		},
+		&cli.StringFlag{
+			Name:  "entrypoint",
+			Usage: "run `COMMAND` instead of the entrypoint of the image, or none when empty",
+		},
+		&cli.StringSliceFlag{
+			Name:    "env",
+			Aliases: []string{"e"},
+			Usage:   "set the `NAME[=VALUE]` variable in the environment of the command",
+		},
+		&cli.StringFlag{
+			Name:    "workdir",
+			Aliases: []string{"w"},
+			Usage:   "run the command in `DIR` instead of the working directory of the image",
+		},
+		&cli.StringFlag{
+			Name:    "root",
+			Aliases: []string{"r"},
+			Usage:   "mount the given `ROOT` directory or image as the root of the container",
+		},
+		&cli.StringFlag{
+			Name:  "image",
+			Usage: "use the imported image `NAME` or ID as the root of the container",
+		},
+		&cli.StringSliceFlag{
+			Name:    "volume",
+			Aliases: []string{"v"},
+			Usage:   "bind mount `SOURCE:TARGET[:OPTIONS]` into the container, OPTIONS being ro, nosuid, nodev, noexec and a propagation",
+		},
+		&cli.StringSliceFlag{
+			Name:  "mount",
+			Usage: "mount `type=TYPE,dst=TARGET[,OPTION...]` into the container, TYPE being bind, tmpfs, volume, proc or sysfs and OPTION being src=SOURCE, ro, size=SIZE, mode=MODE, nosuid, nodev, noexec or propagation=PROPAGATION",
+		},
+		&cli.StringSliceFlag{
+			Name:  "tmpfs",
+			Usage: "mount a tmpfs at `TARGET[:OPTIONS]` in the container, OPTIONS being size=SIZE, mode=MODE, ro, rw, nosuid, nodev and noexec",
+		},
+		&cli.BoolFlag{
+			Name:  "no-proc",
+			Usage: "do not mount /proc in the container",
+		},
+		&cli.BoolFlag{
+			Name:  "no-sysfs",
+			Usage: "do not mount /sys in the container",
+		},
+		&cli.BoolFlag{
+			Name:  "no-devpts",
+			Usage: "do not mount /dev/pts in the container",
+		},
+		&cli.BoolFlag{
+			Name:  "no-shm",
+			Usage: "do not mount /dev/shm in the container",
+		},
+		&cli.StringSliceFlag{
+			Name:  "security-opt",
+			Usage: "set the security `OPTION` seccomp=PROFILE, PROFILE being a seccomp profile in JSON or unconfined",
+		},
+		&cli.BoolFlag{
+			Name:  "seccomp-legacy",
+			Usage: "use the former seccomp profile denying a few syscalls instead of the default allowlist",
+		},
+		&cli.StringSliceFlag{
+			Name:  "device",
+			Usage: "pass the host device `SOURCE[:TARGET][:PERMISSIONS]` through to the container, PERMISSIONS being made of r, w and m",
+		},
+		&cli.StringFlag{
+			Name:    "network",
+			Aliases: []string{"n"},
+			Value:   runtime.NetworkNone,
+			Usage:   "connect the container to the `MODE` network, one of none, host and bridge",
+		},
+		&cli.StringSliceFlag{
+			Name:    "publish",
+			Aliases: []string{"p"},
+			Usage:   "publish a container port on the host with `[HOSTIP:]HOSTPORT:PORT[/PROTO]`",
+		},
+		&cli.BoolFlag{
+			Name:    "publish-all",
+			Aliases: []string{"P"},
+			Usage:   "publish every port exposed by the image on the same port of the host",
+		},
+		&cli.BoolFlag{
+			Name:  "rm",
+			Usage: "remove the container and discard its changes to the root when it exits",
+		},
+		&cli.BoolFlag{
+			Name:  "init",
+			Usage: "run the command under a minimal init that reaps zombies and forwards signals",
+		},
+		&cli.StringFlag{
+			Name:    "memory",
+			Aliases: []string{"m"},
+			Usage:   "limit the memory of the container to `SIZE`, e.g. 512m or 1g",
+		},
+		&cli.StringFlag{
+			Name:  "memory-swap",
+			Usage: "limit the memory plus swap of the container to `SIZE`, -1 for unlimited swap",
+		},
+		&cli.Float64Flag{
+			Name:  "cpus",
+			Usage: "limit the container to `NUMBER` of CPUs, e.g. 1.5",
+		},
+		&cli.Uint64Flag{
+			Name:    "cpu-shares",
+			Aliases: []string{"c"},
+			Usage:   "set the relative CPU `WEIGHT` of the container",
+		},
+		&cli.StringFlag{
+			Name:  "cpuset-cpus",
+			Usage: "run the container on the `CPUS` listed, e.g. 0-2,4",
+		},
+		&cli.Int64Flag{
+			Name:  "pids-limit",
+			Usage: "limit the container to `NUMBER` of processes, -1 for unlimited",
+		},
+		&cli.UintFlag{
+			Name:  "blkio-weight",
+			Usage: "set the relative block IO `WEIGHT` of the container, between 10 and 1000",
+		},
+	}
 }
 
 func main() {
@@ -52,6 +485,9 @@ func main() {
 		Version: "v0.1.0",
 		Usage:   "A minimal container runtime implemented in Go",
 
+		// Mounts and volumes hold commas of their own.
+		DisableSliceFlagSeparator: true,
+
 		Flags: []cli.Flag{
 			&cli.BoolFlag{
 				Name:    "debug",
@@ -59,36 +495,48 @@ func main() {
 				Usage:   "enable debug logging",
 			},
 		},
+		Before: func(c *cli.Context) error {
+			if c.Bool("debug") {
+				logrus.SetLevel(logrus.DebugLevel)
+			}
+			return nil
+		},
 		Commands: []*cli.Command{
 			{
 				Name:      "run",
 				Aliases:   []string{"r"},
 				Usage:     "run an executable in a new container",
-				ArgsUsage: `COMMAND [-- ARGUMENTS]`,
-				Flags: []cli.Flag{
-					&cli.IntFlag{
-						Name:    "uid",
-						Aliases: []string{"u"},
-						Usage:   "create the container with the specified `UID`",
+				ArgsUsage: `[COMMAND [-- ARGUMENTS]]`,
+				Flags: append(containerFlags(),
+					&cli.BoolFlag{
+						Name:    "detach",
+						Aliases: []string{"d"},
+						Usage:   "run the container in the background and print its ID, its output going to container.log in its state directory",
 					},
 					&cli.StringFlag{
-						Name:    "root",
-						Aliases: []string{"r"},
-						Usage:   "mount the root of the container at the given `ROOT`",
-					},
-					&cli.StringSliceFlag{
-						Name:    "volume",
-						Aliases: []string{"v"},
-						Usage:   "mount the given `VOLUME`s into the container",
+						Name:  "seccomp-learn",
+						Usage: "record the syscalls of the container and write a seccomp profile allowing only them to `FILE`",
 					},
-				},
+				),
 				Action: func(c *cli.Context) error {
+					if c.Bool("detach") && c.IsSet("seccomp-learn") {
+						fmt.Fprintln(os.Stderr, "Incorrect Usage: --seccomp-learn cannot be used with --detach")
+						cli.ShowSubcommandHelpAndExit(c, 1)
+					}
 					con, err := newRuntime(c)
 					if err != nil {
 						logrus.Errorf("Fail to create container: %s", err)
 						os.Exit(1)
 					}
 
+					if c.Bool("detach") {
+						err = detach(con)
+						if err != nil {
+							logrus.Errorf("Fail to run container: %s", err)
+						}
+						return err
+					}
+
 					stat, err := con.Run()
 					if err != nil {
 						logrus.Errorf("Fail to run container: %s", err)
@@ -103,36 +551,350 @@ func main() {
 				Name:      "exec",
 				Aliases:   []string{"e"},
 				Usage:     "run an executable in a new container and attach to its stdin, stdout, and stderr",
-				ArgsUsage: `COMMAND [-- ARGUMENTS]`,
+				ArgsUsage: `[COMMAND [-- ARGUMENTS]]`,
+				Flags:     containerFlags(),
+				Action: func(c *cli.Context) error {
+					con, err := newRuntime(c)
+					if err != nil {
+						logrus.Errorf("Fail to create container: %s", err)
+						os.Exit(1)
+					}
+
+					stat, err := con.Exec()
+					if err != nil {
+						logrus.Errorf("Fail to run container: %s", err)
+					} else {
+						logrus.Infof("Container exited with status %d", stat)
+					}
+
+					return err
+				},
+			},
+			{
+				Name:      "create",
+				Usage:     "create a container from an OCI bundle",
+				ArgsUsage: `CONTAINER-ID`,
 				Flags: []cli.Flag{
-					&cli.IntFlag{
-						Name:    "uid",
-						Aliases: []string{"u"},
-						Usage:   "create the container with the specified `UID`",
+					&cli.StringFlag{
+						Name:    "bundle",
+						Aliases: []string{"b"},
+						Value:   ".",
+						Usage:   "load the container from the bundle at `PATH`",
 					},
+				},
+				Action: func(c *cli.Context) error {
+					con, err := runtime.NewFromBundle(containerID(c), c.String("bundle"))
+					if err != nil {
+						logrus.Errorf("Fail to create container: %s", err)
+						os.Exit(1)
+					}
+
+					err = con.Create()
+					if err != nil {
+						logrus.Errorf("Fail to create container: %s", err)
+					}
+
+					return err
+				},
+			},
+			{
+				Name:      "start",
+				Usage:     "execute the user command of a created container",
+				ArgsUsage: `CONTAINER-ID`,
+				Action: func(c *cli.Context) error {
+					err := runtime.Start(containerID(c))
+					if err != nil {
+						logrus.Errorf("Fail to start container: %s", err)
+					}
+
+					return err
+				},
+			},
+			{
+				Name:      "state",
+				Usage:     "output the state of a container",
+				ArgsUsage: `CONTAINER-ID`,
+				Action: func(c *cli.Context) error {
+					st, err := runtime.ReadState(containerID(c))
+					if err != nil {
+						logrus.Errorf("Fail to read container state: %s", err)
+						return err
+					}
+
+					data, err := json.MarshalIndent(st.State, "", "  ")
+					if err != nil {
+						return err
+					}
+					fmt.Println(string(data))
+
+					return nil
+				},
+			},
+			{
+				Name:      "kill",
+				Usage:     "send a signal to the process of a container",
+				ArgsUsage: `CONTAINER-ID [SIGNAL]`,
+				Flags: []cli.Flag{
 					&cli.StringFlag{
-						Name:    "root",
-						Aliases: []string{"r"},
-						Usage:   "mount the root of the container at the given `ROOT`",
+						Name:    "signal",
+						Aliases: []string{"s"},
+						Usage:   "send the signal with the given `NAME` or number instead of SIGTERM",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					id := containerID(c)
+					name := c.String("signal")
+					if name == "" {
+						name = c.Args().Get(1)
+					}
+					sig := syscall.SIGTERM
+					if name != "" {
+						var err error
+						sig, err = parseSignal(name)
+						if err != nil {
+							fmt.Fprintf(os.Stderr, "Incorrect Usage: %s", err)
+							fmt.Fprintln(os.Stderr)
+							cli.ShowSubcommandHelpAndExit(c, 1)
+						}
+					}
+
+					err := runtime.Kill(id, sig)
+					if err != nil {
+						logrus.Errorf("Fail to kill container: %s", err)
+					}
+
+					return err
+				},
+			},
+			{
+				Name:      "delete",
+				Usage:     "delete a container and its state",
+				ArgsUsage: `CONTAINER-ID`,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:    "force",
+						Aliases: []string{"f"},
+						Usage:   "kill the container first if it is still running",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					err := runtime.Delete(containerID(c), c.Bool("force"))
+					if err != nil {
+						logrus.Errorf("Fail to delete container: %s", err)
+					}
+
+					return err
+				},
+			},
+			{
+				Name:    "ps",
+				Aliases: []string{"list"},
+				Usage:   "list containers",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:    "all",
+						Aliases: []string{"a"},
+						Usage:   "show all containers instead of the created and running ones",
 					},
 					&cli.StringSliceFlag{
-						Name:    "volume",
-						Aliases: []string{"v"},
-						Usage:   "mount the given `VOLUME`s into the container",
+						Name:    "filter",
+						Aliases: []string{"f"},
+						Usage:   "only show containers matching `KEY=VALUE`, where KEY is id, status or exited",
+					},
+					&cli.StringFlag{
+						Name:  "format",
+						Value: "table",
+						Usage: "print the containers as a table or as json with `FORMAT`",
 					},
 				},
 				Action: func(c *cli.Context) error {
-					con, err := newRuntime(c)
+					filters, err := parseFilters(c)
+					if err == nil && c.String("format") != "table" && c.String("format") != "json" {
+						err = fmt.Errorf("format must be table or json: %s", c.String("format"))
+					}
 					if err != nil {
-						logrus.Errorf("Fail to create container: %s", err)
-						os.Exit(1)
+						fmt.Fprintf(os.Stderr, "Incorrect Usage: %s", err)
+						fmt.Fprintln(os.Stderr)
+						cli.ShowSubcommandHelpAndExit(c, 1)
 					}
 
-					stat, err := con.Run()
+					infos, err := runtime.List()
 					if err != nil {
-						logrus.Errorf("Fail to run container: %s", err)
-					} else {
-						logrus.Infof("Container exited with status %d", stat)
+						logrus.Errorf("Fail to list containers: %s", err)
+						return err
+					}
+					matched := []*runtime.Info{}
+					for _, info := range infos {
+						active := info.Status == specs.StateCreated || info.Status == specs.StateRunning
+						if (c.Bool("all") || active) && matchFilters(info, filters) {
+							matched = append(matched, info)
+						}
+					}
+
+					if c.String("format") == "json" {
+						data, err := json.MarshalIndent(matched, "", "  ")
+						if err != nil {
+							return err
+						}
+						fmt.Println(string(data))
+						return nil
+					}
+					printContainers(matched)
+
+					return nil
+				},
+			},
+			{
+				Name:      "inspect",
+				Usage:     "output the configuration and live information of a container",
+				ArgsUsage: `CONTAINER-ID`,
+				Action: func(c *cli.Context) error {
+					info, err := runtime.Inspect(containerID(c))
+					if err != nil {
+						logrus.Errorf("Fail to inspect container: %s", err)
+						return err
+					}
+
+					data, err := json.MarshalIndent(info, "", "  ")
+					if err != nil {
+						return err
+					}
+					fmt.Println(string(data))
+
+					return nil
+				},
+			},
+			{
+				Name:   "images",
+				Usage:  "list images",
+				Flags:  imageListFlags(),
+				Action: listImages,
+			},
+			{
+				Name:  "image",
+				Usage: "manage the images containers are run from",
+				Subcommands: []*cli.Command{
+					{
+						Name:      "import",
+						Usage:     "import an OCI image layout or a docker save tarball",
+						ArgsUsage: `PATH`,
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:    "name",
+								Aliases: []string{"n"},
+								Usage:   "import the image as `NAME`, picking it from an archive holding several",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							if c.NArg() < 1 {
+								fmt.Fprintln(os.Stderr, "Incorrect Usage: command needs an argument: path")
+								fmt.Fprintln(os.Stderr)
+								cli.ShowSubcommandHelpAndExit(c, 1)
+							}
+							img, err := runtime.ImportImage(c.Args().First(), c.String("name"))
+							if err != nil {
+								logrus.Errorf("Fail to import image: %s", err)
+								return err
+							}
+							fmt.Printf("Loaded image: %s\n", img.Names[len(img.Names)-1])
+
+							return nil
+						},
+					},
+					{
+						Name:    "ls",
+						Aliases: []string{"list"},
+						Usage:   "list images",
+						Flags:   imageListFlags(),
+						Action:  listImages,
+					},
+					{
+						Name:      "rm",
+						Aliases:   []string{"remove"},
+						Usage:     "untag images and delete the ones left without a name",
+						ArgsUsage: `IMAGE [IMAGE...]`,
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:    "force",
+								Aliases: []string{"f"},
+								Usage:   "delete images even if containers use them",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							imageRef(c)
+							var err error
+							for _, ref := range c.Args().Slice() {
+								rmErr := runtime.RemoveImage(ref, c.Bool("force"))
+								if rmErr != nil {
+									logrus.Errorf("Fail to remove image %s: %s", ref, rmErr)
+									err = rmErr
+								}
+							}
+
+							return err
+						},
+					},
+					{
+						Name:      "inspect",
+						Usage:     "output the record and configuration of an image",
+						ArgsUsage: `IMAGE`,
+						Action: func(c *cli.Context) error {
+							info, err := runtime.InspectImage(imageRef(c))
+							if err != nil {
+								logrus.Errorf("Fail to inspect image: %s", err)
+								return err
+							}
+
+							data, err := json.MarshalIndent(info, "", "  ")
+							if err != nil {
+								return err
+							}
+							fmt.Println(string(data))
+
+							return nil
+						},
+					},
+					{
+						Name:  "prune",
+						Usage: "delete the images without a name and the content no image uses",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:    "all",
+								Aliases: []string{"a"},
+								Usage:   "delete every image no container uses",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							deleted, err := runtime.PruneImages(c.Bool("all"))
+							if err != nil {
+								logrus.Errorf("Fail to prune images: %s", err)
+								return err
+							}
+							for _, id := range deleted {
+								fmt.Println(id)
+							}
+
+							return nil
+						},
+					},
+				},
+			},
+			{
+				Name:            runtime.InitCommand,
+				Hidden:          true,
+				SkipFlagParsing: true,
+				Action: func(c *cli.Context) error {
+					os.Exit(runtime.Init(c.Args().Slice()))
+					return nil
+				},
+			},
+			{
+				Name:   runtime.ShimCommand,
+				Hidden: true,
+				Action: func(c *cli.Context) error {
+					err := runtime.Shim(containerID(c))
+					if err != nil {
+						logrus.Errorf("Fail to run shim: %s", err)
 					}
 
 					return err
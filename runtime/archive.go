@@ -0,0 +1,324 @@
+package runtime
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	imageBlobLimit     = 16 << 20
+	imageMaxLinks      = 8
+	dockerManifestFile = "manifest.json"
+	dockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	dockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	containerdImage    = "io.containerd.image.name"
+)
+
+// blob is a file of an image archive along with its expected digest and
+// size, either of them being empty or negative when unknown.
+type blob struct {
+	path   string
+	digest digest.Digest
+	size   int64
+}
+
+// imageManifest lists the blobs an image is made of.
+type imageManifest struct {
+	names  []string
+	config blob
+	layers []blob
+}
+
+// readDockerManifest reads the manifest of an archive written by docker save.
+func readDockerManifest(src *imageSource, name string) (*imageManifest, error) {
+	data, err := src.readBlob(blob{path: dockerManifestFile, size: -1})
+	if err != nil {
+		return nil, err
+	}
+	entries := []struct {
+		Config   string
+		RepoTags []string
+		Layers   []string
+	}{}
+	err = json.Unmarshal(data, &entries)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidImage, err)
+	}
+
+	index := -1
+	for i, entry := range entries {
+		for _, tag := range entry.RepoTags {
+			if name != "" && normalizeImageName(tag) == normalizeImageName(name) {
+				index = i
+			}
+		}
+	}
+	if index < 0 && len(entries) == 1 {
+		index = 0
+	}
+	if index < 0 {
+		return nil, fmt.Errorf("%w: archive holds %d images, name the one to import", ErrInvalidImage, len(entries))
+	}
+
+	entry := entries[index]
+	manifest := &imageManifest{
+		names:  entry.RepoTags,
+		config: blob{path: entry.Config, digest: digestFromPath(entry.Config), size: -1},
+	}
+	for _, layer := range entry.Layers {
+		manifest.layers = append(manifest.layers, blob{path: layer, digest: digestFromPath(layer), size: -1})
+	}
+
+	return manifest, nil
+}
+
+// readOCIManifest reads the manifest of the image for this platform from an
+// OCI image layout.
+func readOCIManifest(src *imageSource, name string) (*imageManifest, error) {
+	layout := &imagespec.ImageLayout{}
+	err := src.readJSON(blob{path: imagespec.ImageLayoutFile, size: -1}, layout)
+	if err != nil {
+		return nil, err
+	}
+	if layout.Version != imagespec.ImageLayoutVersion {
+		return nil, fmt.Errorf("%w: unsupported layout version %q", ErrInvalidImage, layout.Version)
+	}
+	index := &imagespec.Index{}
+	err = src.readJSON(blob{path: imagespec.ImageIndexFile, size: -1}, index)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		desc  *imagespec.Descriptor
+		names []string
+	)
+	for i, d := range index.Manifests {
+		ref := d.Annotations[containerdImage]
+		if ref == "" {
+			ref = d.Annotations[imagespec.AnnotationRefName]
+		}
+		if len(index.Manifests) == 1 || (name != "" && normalizeImageName(ref) == normalizeImageName(name)) {
+			desc = &index.Manifests[i]
+			if ref != "" {
+				names = []string{ref}
+			}
+		}
+	}
+	if desc == nil {
+		return nil, fmt.Errorf("%w: layout holds %d images, name the one to import", ErrInvalidImage, len(index.Manifests))
+	}
+
+	for desc.MediaType == imagespec.MediaTypeImageIndex || desc.MediaType == dockerManifestList {
+		index = &imagespec.Index{}
+		err = src.readJSON(descriptorBlob(*desc), index)
+		if err != nil {
+			return nil, err
+		}
+		desc = nil
+		for i, d := range index.Manifests {
+			if d.Platform != nil && d.Platform.OS == imageOS && d.Platform.Architecture == imageArch {
+				desc = &index.Manifests[i]
+				break
+			}
+		}
+		if desc == nil {
+			return nil, fmt.Errorf("%w: no image for %s/%s", ErrInvalidImage, imageOS, imageArch)
+		}
+	}
+	if desc.MediaType != imagespec.MediaTypeImageManifest && desc.MediaType != dockerManifest {
+		return nil, fmt.Errorf("%w: unsupported manifest type %q", ErrInvalidImage, desc.MediaType)
+	}
+
+	m := &imagespec.Manifest{}
+	err = src.readJSON(descriptorBlob(*desc), m)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &imageManifest{names: names, config: descriptorBlob(m.Config)}
+	for _, layer := range m.Layers {
+		manifest.layers = append(manifest.layers, descriptorBlob(layer))
+	}
+
+	return manifest, nil
+}
+
+// descriptorBlob returns the blob of an OCI image layout a descriptor
+// points to.
+func descriptorBlob(desc imagespec.Descriptor) blob {
+	return blob{
+		path:   path.Join(imagespec.ImageBlobsDir, strings.Replace(string(desc.Digest), ":", "/", 1)),
+		digest: desc.Digest,
+		size:   desc.Size,
+	}
+}
+
+// digestFromPath returns the digest a docker save archive names a blob
+// after, if any.
+func digestFromPath(p string) digest.Digest {
+	d := digest.NewDigestFromEncoded(digest.SHA256, strings.TrimSuffix(path.Base(p), ".json"))
+	if d.Validate() != nil {
+		return ""
+	}
+	return d
+}
+
+// imageSource reads the files of an image layout directory or tarball.
+type imageSource struct {
+	dir     string
+	file    *os.File
+	entries map[string]*io.SectionReader
+	links   map[string]string
+}
+
+// openImageSource opens an image layout directory or indexes the files of
+// an image tarball.
+func openImageSource(source string) (*imageSource, error) {
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &imageSource{dir: source}, nil
+	}
+
+	file, err := os.Open(source)
+	if err != nil {
+		return nil, err
+	}
+	s := &imageSource{file: file, entries: map[string]*io.SectionReader{}, links: map[string]string{}}
+	reader := tar.NewReader(file)
+	for {
+		hdr, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("%w: %s", ErrInvalidImage, err)
+		}
+		name := path.Clean(hdr.Name)
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			// The reader stops right at the data of the entry.
+			offset, err := file.Seek(0, io.SeekCurrent)
+			if err != nil {
+				file.Close()
+				return nil, err
+			}
+			s.entries[name] = io.NewSectionReader(file, offset, hdr.Size)
+		case tar.TypeSymlink:
+			// Older docker save archives link the duplicate layers.
+			s.links[name] = path.Join(path.Dir(name), hdr.Linkname)
+		}
+	}
+
+	return s, nil
+}
+
+// exists reports whether the source holds the named file.
+func (s *imageSource) exists(name string) bool {
+	if s.file == nil {
+		_, err := os.Stat(filepath.Join(s.dir, path.Clean("/"+name)))
+		return err == nil
+	}
+	name = path.Clean(name)
+	return s.entries[name] != nil || s.links[name] != ""
+}
+
+// open opens the named file of the source and returns its size.
+func (s *imageSource) open(name string) (io.ReadCloser, int64, error) {
+	if s.file == nil {
+		file, err := os.Open(filepath.Join(s.dir, path.Clean("/"+name)))
+		if err != nil {
+			return nil, 0, fmt.Errorf("%w: %s", ErrInvalidImage, err)
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, 0, err
+		}
+		return file, info.Size(), nil
+	}
+
+	name = path.Clean(name)
+	for i := 0; i < imageMaxLinks && s.links[name] != ""; i++ {
+		name = s.links[name]
+	}
+	entry := s.entries[name]
+	if entry == nil {
+		return nil, 0, fmt.Errorf("%w: missing %s", ErrInvalidImage, name)
+	}
+	return io.NopCloser(io.NewSectionReader(entry, 0, entry.Size())), entry.Size(), nil
+}
+
+// openBlob opens a blob after checking its size.
+func (s *imageSource) openBlob(b blob) (io.ReadCloser, error) {
+	if b.digest != "" {
+		err := b.digest.Validate()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %s", ErrInvalidImage, b.path, err)
+		}
+	}
+	file, size, err := s.open(b.path)
+	if err != nil {
+		return nil, err
+	}
+	if b.size >= 0 && size != b.size {
+		file.Close()
+		return nil, fmt.Errorf("%w: %s has size %d, expected %d", ErrInvalidImage, b.path, size, b.size)
+	}
+
+	return file, nil
+}
+
+// readBlob reads a small blob and checks its digest.
+func (s *imageSource) readBlob(b blob) ([]byte, error) {
+	file, err := s.openBlob(b)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, imageBlobLimit+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > imageBlobLimit {
+		return nil, fmt.Errorf("%w: %s is too large", ErrInvalidImage, b.path)
+	}
+	if b.digest != "" && b.digest.Algorithm().FromBytes(data) != b.digest {
+		return nil, fmt.Errorf("%w: digest of %s does not match %s", ErrInvalidImage, b.path, b.digest)
+	}
+
+	return data, nil
+}
+
+// readJSON reads a blob holding a JSON document into v.
+func (s *imageSource) readJSON(b blob, v any) error {
+	data, err := s.readBlob(b)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %s", ErrInvalidImage, b.path, err)
+	}
+	return nil
+}
+
+// close releases the source.
+func (s *imageSource) close() {
+	if s.file != nil {
+		s.file.Close()
+	}
+}
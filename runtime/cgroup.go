@@ -0,0 +1,384 @@
+package runtime
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	goruntime "runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/containerd/cgroups/v3"
+	"github.com/containerd/cgroups/v3/cgroup1"
+	"github.com/containerd/cgroups/v3/cgroup2"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	cgroupPrefix         = "/gophinator"
+	cgroupRoot           = "/sys/fs/cgroup"
+	cgroupDeleteRetries  = 20
+	cgroupDeleteInterval = 50 * time.Millisecond
+	cgroupCPUPeriod      = 100000
+	cgroupMinMemory      = 6 * 1024 * 1024
+	cgroupMinCPUShares   = 2
+	cgroupMaxCPUShares   = 262144
+	cgroupMinBlkIOWeight = 10
+	cgroupMaxBlkIOWeight = 1000
+)
+
+// Resources are the limits of a container started from the command line.
+// Zero values keep the default limits.
+type Resources struct {
+	// Memory is the memory limit in bytes.
+	Memory int64
+	// MemorySwap is the memory plus swap limit in bytes, -1 for unlimited swap.
+	MemorySwap int64
+	// CPUs is the number of CPUs the container may use.
+	CPUs float64
+	// CPUShares is the relative CPU weight.
+	CPUShares uint64
+	// CpusetCPUs is the list of CPUs the container may run on, e.g. "0-2,4".
+	CpusetCPUs string
+	// PidsLimit is the maximum number of processes, -1 for unlimited.
+	PidsLimit int64
+	// BlkIOWeight is the relative block IO weight.
+	BlkIOWeight uint16
+}
+
+// Validate checks that the resources can be applied on this host.
+func (res *Resources) Validate() error {
+	switch {
+	case res.Memory < 0:
+		return fmt.Errorf("%w: memory must not be negative", ErrInvalidResources)
+	case res.Memory > 0 && res.Memory < cgroupMinMemory:
+		return fmt.Errorf("%w: memory must be at least 6MiB", ErrInvalidResources)
+	case res.MemorySwap < -1:
+		return fmt.Errorf("%w: memory swap must be -1 or positive", ErrInvalidResources)
+	case res.MemorySwap != 0 && res.Memory == 0:
+		return fmt.Errorf("%w: memory swap requires a memory limit", ErrInvalidResources)
+	case res.MemorySwap > 0 && res.MemorySwap < res.Memory:
+		return fmt.Errorf("%w: memory swap must not be smaller than memory", ErrInvalidResources)
+	case res.CPUs < 0:
+		return fmt.Errorf("%w: cpus must not be negative", ErrInvalidResources)
+	case res.CPUs > float64(goruntime.NumCPU()):
+		return fmt.Errorf("%w: cpus must not exceed the %d available CPUs", ErrInvalidResources, goruntime.NumCPU())
+	case res.CPUShares != 0 && (res.CPUShares < cgroupMinCPUShares || res.CPUShares > cgroupMaxCPUShares):
+		return fmt.Errorf("%w: cpu shares must be between %d and %d", ErrInvalidResources, cgroupMinCPUShares, cgroupMaxCPUShares)
+	case res.PidsLimit < -1:
+		return fmt.Errorf("%w: pids limit must be -1 or positive", ErrInvalidResources)
+	case res.BlkIOWeight != 0 && (res.BlkIOWeight < cgroupMinBlkIOWeight || res.BlkIOWeight > cgroupMaxBlkIOWeight):
+		return fmt.Errorf("%w: blkio weight must be between %d and %d", ErrInvalidResources, cgroupMinBlkIOWeight, cgroupMaxBlkIOWeight)
+	}
+
+	return validateCpuset(res.CpusetCPUs)
+}
+
+// linuxResources returns the default resources overridden by the ones set.
+func (res *Resources) linuxResources() *specs.LinuxResources {
+	resources := defaultResources()
+	if res.Memory > 0 {
+		resources.Memory.Limit = &res.Memory
+	}
+	if res.MemorySwap != 0 {
+		resources.Memory.Swap = &res.MemorySwap
+	}
+	if res.CPUs > 0 {
+		period := uint64(cgroupCPUPeriod)
+		quota := int64(res.CPUs * cgroupCPUPeriod)
+		resources.CPU.Period = &period
+		resources.CPU.Quota = &quota
+	}
+	if res.CPUShares > 0 {
+		resources.CPU.Shares = &res.CPUShares
+	}
+	if res.CpusetCPUs != "" {
+		resources.CPU.Cpus = res.CpusetCPUs
+	}
+	if res.PidsLimit != 0 {
+		resources.Pids.Limit = res.PidsLimit
+	}
+	if res.BlkIOWeight > 0 {
+		resources.BlockIO.Weight = &res.BlkIOWeight
+	}
+
+	return resources
+}
+
+// validateCpuset checks that a cpuset list such as "0-2,4" only names CPUs
+// available on this host.
+func validateCpuset(cpuset string) error {
+	if cpuset == "" {
+		return nil
+	}
+
+	for _, chunk := range strings.Split(cpuset, ",") {
+		first, last, isRange := strings.Cut(chunk, "-")
+		low, err := strconv.Atoi(first)
+		if err != nil || low < 0 {
+			return fmt.Errorf("%w: invalid cpuset %q", ErrInvalidResources, cpuset)
+		}
+		high := low
+		if isRange {
+			high, err = strconv.Atoi(last)
+			if err != nil || high < low {
+				return fmt.Errorf("%w: invalid cpuset %q", ErrInvalidResources, cpuset)
+			}
+		}
+		if high >= goruntime.NumCPU() {
+			return fmt.Errorf("%w: cpuset %q names unavailable CPU %d", ErrInvalidResources, cpuset, high)
+		}
+	}
+
+	return nil
+}
+
+// cgroup is the control group of a container, backed by either the legacy
+// or the unified hierarchy.
+type cgroup interface {
+	add(pid uintptr) error
+	setIOWeight(weight uint16) error
+	kill() error
+	delete() error
+	path() string
+}
+
+// cgroupV1 is a control group in the legacy hierarchy.
+type cgroupV1 struct {
+	control cgroup1.Cgroup
+	group   string
+}
+
+func (c *cgroupV1) add(pid uintptr) error {
+	return c.control.Add(cgroup1.Process{Pid: int(pid)})
+}
+
+func (c *cgroupV1) setIOWeight(weight uint16) error {
+	dir := filepath.Join(cgroupRoot, "blkio", c.group)
+	return writeCgroupFile(dir, []string{"blkio.weight", "blkio.bfq.weight"}, fmt.Sprint(weight))
+}
+
+func (c *cgroupV1) kill() error {
+	subsystems := c.control.Subsystems()
+	if len(subsystems) == 0 {
+		return nil
+	}
+	procs, err := c.control.Processes(subsystems[0].Name(), true)
+	if err != nil {
+		return err
+	}
+	for _, proc := range procs {
+		syscall.Kill(proc.Pid, syscall.SIGKILL)
+	}
+	return nil
+}
+
+func (c *cgroupV1) delete() error {
+	return c.control.Delete()
+}
+
+func (c *cgroupV1) path() string {
+	return c.group
+}
+
+// cgroupV2 is a control group in the unified hierarchy.
+type cgroupV2 struct {
+	manager *cgroup2.Manager
+	group   string
+}
+
+func (c *cgroupV2) add(pid uintptr) error {
+	return c.manager.AddProc(uint64(pid))
+}
+
+func (c *cgroupV2) setIOWeight(weight uint16) error {
+	dir := filepath.Join(cgroupRoot, c.group)
+	err := writeCgroupFile(dir, []string{"io.bfq.weight"}, fmt.Sprint(weight))
+	if err != nil {
+		converted := 1 + (uint64(weight)-10)*9999/990
+		err = writeCgroupFile(dir, []string{"io.weight"}, fmt.Sprintf("default %d", converted))
+	}
+	return err
+}
+
+func (c *cgroupV2) kill() error {
+	return c.manager.Kill()
+}
+
+func (c *cgroupV2) delete() error {
+	return c.manager.Delete()
+}
+
+func (c *cgroupV2) path() string {
+	return c.group
+}
+
+// defaultResources returns the resources of a container started from the
+// command line without limits of its own.
+func defaultResources() *specs.LinuxResources {
+	var (
+		cgroupCPUShare    uint64 = 256
+		cgroupMemoryLimit int64  = 1024 * 1024 * 1024
+		cgroupPidLimit    int64  = 64
+		cgroupBlkIOWeight uint16 = 50
+	)
+
+	return &specs.LinuxResources{
+		CPU: &specs.LinuxCPU{
+			Shares: &cgroupCPUShare,
+		},
+		Memory: &specs.LinuxMemory{
+			Limit: &cgroupMemoryLimit,
+		},
+		Pids: &specs.LinuxPids{
+			Limit: cgroupPidLimit,
+		},
+		BlockIO: &specs.LinuxBlockIO{
+			Weight: &cgroupBlkIOWeight,
+		},
+	}
+}
+
+// cgroupPath returns the cgroup of the container with the given spec and ID.
+func cgroupPath(spec *specs.Spec, id string) string {
+	if spec.Linux != nil && spec.Linux.CgroupsPath != "" {
+		return spec.Linux.CgroupsPath
+	}
+	return cgroupPrefix + "/" + id
+}
+
+// setupCgroup places the process in a new cgroup limited by resources, using
+// the hierarchy mounted on the host. Without resources, as for bundles
+// setting none, the cgroup is not limited. It returns nil when the host has
+// no cgroup hierarchy at all.
+func setupCgroup(group string, resources *specs.LinuxResources, pid uintptr) (cgroup, error) {
+	if resources == nil {
+		resources = &specs.LinuxResources{}
+	}
+	// The block IO weight file only exists with some IO schedulers, so the
+	// weight is applied on its own and failing to do so is not fatal.
+	var weight *uint16
+	if resources.BlockIO != nil && resources.BlockIO.Weight != nil {
+		weight = resources.BlockIO.Weight
+		stripped, blockIO := *resources, *resources.BlockIO
+		blockIO.Weight = nil
+		stripped.BlockIO = &blockIO
+		resources = &stripped
+	}
+
+	var (
+		control cgroup
+		err     error
+	)
+	switch cgroups.Mode() {
+	case cgroups.Unified:
+		logrus.Debugf("Detecting cgroup v2 unified hierarchy")
+		control, err = newCgroupV2(group, resources)
+	case cgroups.Legacy, cgroups.Hybrid:
+		logrus.Debugf("Detecting cgroup v1 hierarchy")
+		control, err = newCgroupV1(group, resources)
+	case cgroups.Unavailable:
+		logrus.Warnf("No cgroup hierarchy is mounted, resources are not limited")
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if weight != nil {
+		err = control.setIOWeight(*weight)
+		if err != nil {
+			logrus.Warnf("Fail to set IO weight: %s", err)
+		}
+	}
+
+	err = control.add(pid)
+	if err != nil {
+		control.delete()
+		return nil, err
+	}
+
+	return control, nil
+}
+
+// newCgroupV1 creates a cgroup in every legacy hierarchy.
+func newCgroupV1(group string, resources *specs.LinuxResources) (cgroup, error) {
+	control, err := cgroup1.New(cgroup1.StaticPath(group), resources)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cgroupV1{control: control, group: group}, nil
+}
+
+// newCgroupV2 creates a cgroup in the unified hierarchy.
+func newCgroupV2(group string, resources *specs.LinuxResources) (cgroup, error) {
+	converted := cgroup2.ToResources(resources)
+	// Devices are left out of the conversion, and the filter the manager
+	// builds for them wants the wildcards of the spec spelled out as -1.
+	for _, rule := range resources.Devices {
+		wildcard := int64(-1)
+		if rule.Type == "" {
+			rule.Type = "a"
+		}
+		if rule.Major == nil {
+			rule.Major = &wildcard
+		}
+		if rule.Minor == nil {
+			rule.Minor = &wildcard
+		}
+		converted.Devices = append(converted.Devices, rule)
+	}
+	// cgroup v1 limits memory and swap together, cgroup v2 limits swap alone.
+	// A new cgroup does not limit swap, which is what -1 asks for.
+	mem := resources.Memory
+	if mem != nil && mem.Swap != nil && *mem.Swap < 0 {
+		converted.Memory.Swap = nil
+	}
+	if mem != nil && mem.Swap != nil && mem.Limit != nil && *mem.Swap > 0 && *mem.Limit > 0 {
+		swap := *mem.Swap - *mem.Limit
+		converted.Memory.Swap = &swap
+	}
+
+	manager, err := cgroup2.NewManager(cgroupRoot, group, converted)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cgroupV2{manager: manager, group: group}, nil
+}
+
+// writeCgroupFile writes value to the first of the given files that exists
+// in the cgroup directory.
+func writeCgroupFile(dir string, files []string, value string) error {
+	err := os.ErrNotExist
+	for _, file := range files {
+		err = os.WriteFile(filepath.Join(dir, file), []byte(value), 0)
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// cleanupCgroup kills the processes left in the cgroup and deletes it,
+// retrying while the kernel is still tearing the processes down.
+func cleanupCgroup(control cgroup) {
+	err := control.kill()
+	if err != nil {
+		logrus.Debugf("Killing processes in cgroup %s failed: %s", control.path(), err)
+	}
+
+	for i := 0; i < cgroupDeleteRetries; i++ {
+		err = control.delete()
+		if err == nil {
+			logrus.Debugf("Deleting cgroup %s", control.path())
+			return
+		}
+		time.Sleep(cgroupDeleteInterval)
+	}
+	logrus.Warnf("Fail to delete cgroup %s: %s", control.path(), err)
+}
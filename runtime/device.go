@@ -0,0 +1,263 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	devicePermissions = "rwm"
+	devicePtsMajor    = 136
+)
+
+// Device is a host device passed through to a container.
+type Device struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	// Permissions are the accesses allowed to the device, made of r for
+	// reading, w for writing and m for creating it with mknod.
+	Permissions string `json:"permissions"`
+}
+
+// ParseDevice parses a device in the form source[:target][:permissions],
+// the target defaulting to the source and the permissions to rwm.
+func ParseDevice(s string) (Device, error) {
+	chunks := strings.Split(s, ":")
+	if len(chunks) > 3 || chunks[0] == "" {
+		return Device{}, fmt.Errorf("%w: %q", ErrInvalidDevice, s)
+	}
+	device := Device{Source: chunks[0], Target: chunks[0], Permissions: devicePermissions}
+	switch {
+	case len(chunks) == 3:
+		device.Target, device.Permissions = chunks[1], chunks[2]
+	case len(chunks) == 2 && path.IsAbs(chunks[1]):
+		device.Target = chunks[1]
+	case len(chunks) == 2:
+		device.Permissions = chunks[1]
+	}
+
+	return device, device.validate()
+}
+
+// validate checks that the paths of the device are absolute and that its
+// permissions are a subset of rwm.
+func (d Device) validate() error {
+	if !path.IsAbs(d.Source) || !path.IsAbs(d.Target) {
+		return fmt.Errorf("%w: %s:%s is not absolute", ErrInvalidDevice, d.Source, d.Target)
+	}
+	if d.Permissions == "" {
+		return fmt.Errorf("%w: no permissions", ErrInvalidDevice)
+	}
+	for i, c := range d.Permissions {
+		if !strings.ContainsRune(devicePermissions, c) || strings.ContainsRune(d.Permissions[i+1:], c) {
+			return fmt.Errorf("%w: invalid permissions %q", ErrInvalidDevice, d.Permissions)
+		}
+	}
+
+	return nil
+}
+
+// linuxDevice returns the device of the spec creating d in the container
+// along with the rule allowing its access.
+func (d Device) linuxDevice() (specs.LinuxDevice, specs.LinuxDeviceCgroup, error) {
+	var stat unix.Stat_t
+	err := unix.Stat(d.Source, &stat)
+	if err != nil {
+		return specs.LinuxDevice{}, specs.LinuxDeviceCgroup{}, err
+	}
+	var kind string
+	switch stat.Mode & unix.S_IFMT {
+	case unix.S_IFCHR:
+		kind = "c"
+	case unix.S_IFBLK:
+		kind = "b"
+	default:
+		return specs.LinuxDevice{}, specs.LinuxDeviceCgroup{}, fmt.Errorf("%w: %s is not a device", ErrInvalidDevice, d.Source)
+	}
+
+	mode := os.FileMode(stat.Mode & 0777)
+	device := specs.LinuxDevice{
+		Path:     path.Clean(d.Target),
+		Type:     kind,
+		Major:    int64(unix.Major(stat.Rdev)),
+		Minor:    int64(unix.Minor(stat.Rdev)),
+		FileMode: &mode,
+		UID:      &stat.Uid,
+		GID:      &stat.Gid,
+	}
+	return device, deviceRule(device, d.Permissions), nil
+}
+
+// defaultDevices returns the devices of every container started from the
+// command line, along with the rules denying the access to any other one.
+// Terminals are allowed as well since the console and the pseudo-terminals
+// of devpts are not created as devices.
+func defaultDevices() ([]specs.LinuxDevice, []specs.LinuxDeviceCgroup) {
+	var nodes = []struct {
+		name         string
+		major, minor int64
+	}{
+		{"null", 1, 3},
+		{"zero", 1, 5},
+		{"full", 1, 7},
+		{"random", 1, 8},
+		{"urandom", 1, 9},
+		{"tty", 5, 0},
+	}
+
+	mode := os.FileMode(0666)
+	devices := []specs.LinuxDevice{}
+	rules := []specs.LinuxDeviceCgroup{{Allow: false, Access: devicePermissions}}
+	for _, node := range nodes {
+		device := specs.LinuxDevice{
+			Path:     "/dev/" + node.name,
+			Type:     "c",
+			Major:    node.major,
+			Minor:    node.minor,
+			FileMode: &mode,
+		}
+		devices = append(devices, device)
+		rules = append(rules, deviceRule(device, devicePermissions))
+	}
+	ttyMajor, ptmxMinor, ptsMajor := int64(5), int64(2), int64(devicePtsMajor)
+	rules = append(rules,
+		specs.LinuxDeviceCgroup{Allow: true, Type: "c", Major: &ttyMajor, Minor: &ptmxMinor, Access: "rw"},
+		specs.LinuxDeviceCgroup{Allow: true, Type: "c", Major: &ptsMajor, Access: "rw"},
+	)
+
+	return devices, rules
+}
+
+// deviceRule returns the rule allowing the given access to a device.
+func deviceRule(device specs.LinuxDevice, access string) specs.LinuxDeviceCgroup {
+	major, minor := device.Major, device.Minor
+	return specs.LinuxDeviceCgroup{Allow: true, Type: device.Type, Major: &major, Minor: &minor, Access: access}
+}
+
+// needsDevices reports whether the devices of the container are created in
+// its /dev, which is not the case when /dev is bind mounted from the host.
+func needsDevices(spec *specs.Spec) bool {
+	for i := range spec.Mounts {
+		m := &spec.Mounts[i]
+		if path.Clean(m.Destination) == "/dev" && isBindMount(m) {
+			return false
+		}
+	}
+	return true
+}
+
+// setupDevices creates the devices of the container below root along with
+// the standard links of /dev, and binds its terminal to /dev/console.
+func setupDevices(rt *Runtime, root string) error {
+	for _, device := range rt.spec.Linux.Devices {
+		err := createDevice(root, device)
+		if err != nil {
+			return fmt.Errorf("creating device %s: %w", device.Path, err)
+		}
+		logrus.Debugf("Creating device %s", device.Path)
+	}
+
+	var links = [][2]string{
+		{"/proc/self/fd", "/dev/fd"},
+		{"/proc/self/fd/0", "/dev/stdin"},
+		{"/proc/self/fd/1", "/dev/stdout"},
+		{"/proc/self/fd/2", "/dev/stderr"},
+	}
+	for _, m := range rt.spec.Mounts {
+		if m.Type == MountDevpts && path.Clean(m.Destination) == "/dev/pts" {
+			links = append(links, [2]string{"pts/ptmx", "/dev/ptmx"})
+		}
+	}
+	for _, link := range links {
+		target := filepath.Join(root, link[1])
+		err := os.Symlink(link[0], target)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if rt.pts != nil {
+		err := bindDevice(rt.pts.Name(), filepath.Join(root, "/dev/console"))
+		if err != nil {
+			return fmt.Errorf("binding console: %w", err)
+		}
+		logrus.Debugf("Binding console %s", rt.pts.Name())
+	}
+
+	return nil
+}
+
+// createDevice creates a device node below root. Creating devices is not
+// permitted in a user namespace, in which case the device of the same path
+// on the host is bind mounted instead.
+func createDevice(root string, device specs.LinuxDevice) error {
+	var kinds = map[string]uint32{
+		"c": unix.S_IFCHR,
+		"u": unix.S_IFCHR,
+		"b": unix.S_IFBLK,
+		"p": unix.S_IFIFO,
+	}
+	kind, ok := kinds[device.Type]
+	if !ok {
+		return fmt.Errorf("%w: unknown type %q", ErrInvalidDevice, device.Type)
+	}
+	mode := os.FileMode(0666)
+	if device.FileMode != nil {
+		mode = device.FileMode.Perm()
+	}
+
+	target := filepath.Join(root, filepath.Clean("/"+device.Path))
+	err := os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(target)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	dev := unix.Mkdev(uint32(device.Major), uint32(device.Minor))
+	err = unix.Mknod(target, kind|uint32(mode), int(dev))
+	if errors.Is(err, syscall.EPERM) && kind != unix.S_IFIFO {
+		return bindDevice(device.Path, target)
+	}
+	if err != nil {
+		return err
+	}
+
+	// The mode given to mknod is subject to the umask.
+	err = os.Chmod(target, mode)
+	if err != nil {
+		return err
+	}
+	uid, gid := -1, -1
+	if device.UID != nil {
+		uid = int(*device.UID)
+	}
+	if device.GID != nil {
+		gid = int(*device.GID)
+	}
+	return os.Lchown(target, uid, gid)
+}
+
+// bindDevice bind mounts the host device source at target.
+func bindDevice(source string, target string) error {
+	file, err := os.OpenFile(target, os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	file.Close()
+
+	return syscall.Mount(source, target, "", syscall.MS_BIND, "")
+}
@@ -0,0 +1,44 @@
+package runtime
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseDevice(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Device
+		err  error
+	}{
+		{"/dev/fuse", Device{Source: "/dev/fuse", Target: "/dev/fuse", Permissions: "rwm"}, nil},
+		{"/dev/fuse:/dev/f", Device{Source: "/dev/fuse", Target: "/dev/f", Permissions: "rwm"}, nil},
+		{"/dev/fuse:r", Device{Source: "/dev/fuse", Target: "/dev/fuse", Permissions: "r"}, nil},
+		{"/dev/fuse:/dev/f:wr", Device{Source: "/dev/fuse", Target: "/dev/f", Permissions: "wr"}, nil},
+		{"", Device{}, ErrInvalidDevice},
+		{":/dev/f", Device{}, ErrInvalidDevice},
+		{"dev/fuse", Device{}, ErrInvalidDevice},
+		{"/dev/fuse:dev/f:r", Device{}, ErrInvalidDevice},
+		{"/dev/fuse:", Device{}, ErrInvalidDevice},
+		{"/dev/fuse:rx", Device{}, ErrInvalidDevice},
+		{"/dev/fuse:rr", Device{}, ErrInvalidDevice},
+		{"/dev/fuse:/dev/f:r:w", Device{}, ErrInvalidDevice},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseDevice(tt.in)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("ParseDevice(%q) error = %v, want %v", tt.in, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseDevice(%q) error = %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseDevice(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
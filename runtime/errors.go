@@ -3,7 +3,27 @@ package runtime
 import "errors"
 
 var (
-	ErrUnsupportedArch    = errors.New("unsupported architecture")
-	ErrUnsupportedOS      = errors.New("unsupported operating system")
-	ErrUnsupportedVersion = errors.New("unsupported kernel version")
+	ErrUnsupportedArch      = errors.New("unsupported architecture")
+	ErrUnsupportedOS        = errors.New("unsupported operating system")
+	ErrUnsupportedVersion   = errors.New("unsupported kernel version")
+	ErrUnsupportedNamespace = errors.New("unsupported namespace")
+	ErrInvalidID            = errors.New("invalid container id")
+	ErrInvalidSpec          = errors.New("invalid runtime spec")
+	ErrInvalidResources     = errors.New("invalid resource limits")
+	ErrInvalidNetwork       = errors.New("invalid network mode")
+	ErrInvalidPort          = errors.New("invalid port mapping")
+	ErrInvalidVolume        = errors.New("invalid volume")
+	ErrContainerExists      = errors.New("container already exists")
+	ErrContainerNotExist    = errors.New("container does not exist")
+	ErrContainerNotCreated  = errors.New("container is not created")
+	ErrContainerNotRunning  = errors.New("container is not running")
+	ErrContainerNotStopped  = errors.New("container is not stopped")
+	ErrShimExited           = errors.New("shim exited unexpectedly")
+	ErrNetworkExhausted     = errors.New("no address left in network")
+	ErrInvalidImage         = errors.New("invalid image")
+	ErrImageNotExist        = errors.New("image does not exist")
+	ErrImageInUse           = errors.New("image is in use")
+	ErrInvalidMount         = errors.New("invalid mount")
+	ErrInvalidDevice        = errors.New("invalid device")
+	ErrInvalidSeccomp       = errors.New("invalid seccomp profile")
 )
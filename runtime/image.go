@@ -0,0 +1,659 @@
+package runtime
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	imageRoot         = "/var/lib/gophinator"
+	imageBlobDir      = imageRoot + "/blobs"
+	imageLayerDir     = imageRoot + "/layers"
+	imageIndexFile    = imageRoot + "/images.json"
+	imageTmpPrefix    = ".tmp-"
+	imageOS           = "linux"
+	imageArch         = "amd64"
+	annotationImage   = "org.gophinator.image"
+	annotationImageID = "org.gophinator.image.id"
+	annotationLayers  = "org.gophinator.layers"
+)
+
+// Image is an image imported into the local store, identified by the digest
+// of its configuration.
+type Image struct {
+	ID      digest.Digest `json:"id"`
+	Names   []string      `json:"names"`
+	Layers  []Layer       `json:"layers"`
+	Size    int64         `json:"size"`
+	Created time.Time     `json:"created"`
+}
+
+// Layer is a layer of an image, stored both as a blob and unpacked in a
+// directory shared by every image it belongs to.
+type Layer struct {
+	Digest digest.Digest `json:"digest"`
+	DiffID digest.Digest `json:"diffID"`
+	Size   int64         `json:"size"`
+}
+
+// ImageInfo is an image along with its configuration.
+type ImageInfo struct {
+	*Image
+	Config *imagespec.Image `json:"config"`
+}
+
+// imageStore is the index of the imported images by ID.
+type imageStore struct {
+	Images map[digest.Digest]*Image `json:"images"`
+}
+
+// ImportImage imports the image stored in an OCI image layout or a docker
+// save tarball under the given name. The name also picks the image out of
+// an archive holding several; when empty, the name recorded in the archive
+// is used. Blobs and layers already in the store are not stored again.
+func ImportImage(source string, name string) (*Image, error) {
+	src, err := openImageSource(source)
+	if err != nil {
+		return nil, err
+	}
+	defer src.close()
+
+	var manifest *imageManifest
+	switch {
+	case src.exists(dockerManifestFile):
+		manifest, err = readDockerManifest(src, name)
+	case src.exists(imagespec.ImageLayoutFile):
+		manifest, err = readOCIManifest(src, name)
+	default:
+		err = fmt.Errorf("%w: %s is neither an OCI layout nor a docker save archive", ErrInvalidImage, source)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		if len(manifest.names) == 0 {
+			return nil, fmt.Errorf("%w: image has no name, give it one", ErrInvalidImage)
+		}
+		name = manifest.names[0]
+	}
+	name = normalizeImageName(name)
+
+	data, err := src.readBlob(manifest.config)
+	if err != nil {
+		return nil, err
+	}
+	config := &imagespec.Image{}
+	err = json.Unmarshal(data, config)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidImage, err)
+	}
+	if config.OS != imageOS || config.Architecture != imageArch {
+		return nil, fmt.Errorf("%w: image is built for %s/%s", ErrInvalidImage, config.OS, config.Architecture)
+	}
+	if len(config.RootFS.DiffIDs) != len(manifest.layers) {
+		return nil, fmt.Errorf("%w: image has %d layers but %d diff IDs", ErrInvalidImage, len(manifest.layers), len(config.RootFS.DiffIDs))
+	}
+	if len(manifest.layers) == 0 {
+		return nil, fmt.Errorf("%w: image has no layers", ErrInvalidImage)
+	}
+
+	var img *Image
+	err = updateImages(func(store *imageStore) error {
+		id, size, err := storeBlob(bytes.NewReader(data), digest.FromBytes(data))
+		if err != nil {
+			return err
+		}
+		img = &Image{ID: id, Size: size, Created: time.Now()}
+		for i, b := range manifest.layers {
+			layer, err := storeLayer(src, b, config.RootFS.DiffIDs[i])
+			if err != nil {
+				return err
+			}
+			img.Layers = append(img.Layers, *layer)
+			img.Size += layer.Size
+		}
+
+		for _, other := range store.Images {
+			other.Names = removeName(other.Names, name)
+		}
+		if old := store.Images[id]; old != nil {
+			img.Names, img.Created = old.Names, old.Created
+		}
+		img.Names = append(img.Names, name)
+		store.Images[id] = img
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	logrus.Infof("Imported image %s as %s", img.ID, name)
+
+	return img, nil
+}
+
+// Images returns every imported image, the most recent first.
+func Images() ([]*Image, error) {
+	images := []*Image{}
+	err := updateImages(func(store *imageStore) error {
+		for _, img := range store.Images {
+			images = append(images, img)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].Created.After(images[j].Created)
+	})
+
+	return images, nil
+}
+
+// LookupImage returns the imported image with the given name or ID.
+func LookupImage(ref string) (*Image, error) {
+	var img *Image
+	err := updateImages(func(store *imageStore) error {
+		var err error
+		img, err = resolveImage(store, ref)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return img, nil
+}
+
+// InspectImage returns the image with the given name or ID along with its
+// configuration.
+func InspectImage(ref string) (*ImageInfo, error) {
+	img, err := LookupImage(ref)
+	if err != nil {
+		return nil, err
+	}
+	config, err := readImageConfig(img)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ImageInfo{Image: img, Config: config}, nil
+}
+
+// RemoveImage removes a name from its image, or every name when the image
+// is given by ID, and deletes the image once it has no name left. An image
+// used by a container is only deleted when force is set.
+func RemoveImage(ref string, force bool) error {
+	users, err := imageUsers()
+	if err != nil {
+		return err
+	}
+
+	return updateImages(func(store *imageStore) error {
+		img, err := resolveImage(store, ref)
+		if err != nil {
+			return err
+		}
+		name := normalizeImageName(ref)
+		names := removeName(img.Names, name)
+		if len(names) < len(img.Names) && len(names) > 0 {
+			img.Names = names
+			logrus.Infof("Untagged %s", name)
+			return nil
+		}
+		if users[img.ID] != "" && !force {
+			return fmt.Errorf("%w: %s is used by container %s", ErrImageInUse, ref, users[img.ID])
+		}
+
+		delete(store.Images, img.ID)
+		logrus.Infof("Deleted image %s", img.ID)
+		return cleanupImageContent(store)
+	})
+}
+
+// PruneImages deletes the images without any name, or every image no
+// container uses when all is set, along with the content no image refers
+// to. It returns the IDs of the deleted images.
+func PruneImages(all bool) ([]digest.Digest, error) {
+	users, err := imageUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	deleted := []digest.Digest{}
+	err = updateImages(func(store *imageStore) error {
+		for id, img := range store.Images {
+			if users[id] == "" && (all || len(img.Names) == 0) {
+				delete(store.Images, id)
+				deleted = append(deleted, id)
+				logrus.Infof("Deleted image %s", id)
+			}
+		}
+		return cleanupImageContent(store)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return deleted, nil
+}
+
+// useImage makes the image the root of the spec, its layers being mounted
+// as the lower layers of the overlay.
+func useImage(spec *specs.Spec, ref string, img *Image) error {
+	dirs := []string{}
+	for i := len(img.Layers) - 1; i >= 0; i-- {
+		dir := layerPath(img.Layers[i].DiffID)
+		_, err := os.Stat(dir)
+		if err != nil {
+			return fmt.Errorf("%w: layer %s is missing, import the image again", ErrInvalidImage, img.Layers[i].DiffID)
+		}
+		dirs = append(dirs, dir)
+	}
+
+	spec.Root.Path = dirs[0]
+	spec.Annotations[annotationImage] = ref
+	spec.Annotations[annotationImageID] = string(img.ID)
+	spec.Annotations[annotationLayers] = strings.Join(dirs, ":")
+	return nil
+}
+
+// applyImageConfig makes the configuration of an image the defaults of the
+// process of the spec, argv and the options taking precedence over it.
+func applyImageConfig(spec *specs.Spec, config *imagespec.ImageConfig, argv []string, opts *Options) error {
+	entrypoint, cmd := config.Entrypoint, config.Cmd
+	if opts.Entrypoint != nil {
+		// Overriding the entrypoint drops the command meant for the old one.
+		entrypoint, cmd = opts.Entrypoint, nil
+	}
+	if len(argv) > 0 {
+		cmd = argv
+	}
+	spec.Process.Args = append(append([]string{}, entrypoint...), cmd...)
+
+	env := config.Env
+	if !hasEnv(env, "PATH") {
+		env = append([]string{"PATH=" + processDefaultPath}, env...)
+	}
+	spec.Process.Env = mergeEnv(env, opts.Env)
+	if opts.Workdir == "" && config.WorkingDir != "" {
+		spec.Process.Cwd = config.WorkingDir
+	}
+	if opts.User == "" && config.User != "" {
+		user, err := resolveUser(strings.Split(spec.Annotations[annotationLayers], ":"), config.User)
+		if err != nil {
+			return err
+		}
+		spec.Process.User = user
+	}
+
+	if !opts.PublishAll || len(config.ExposedPorts) == 0 {
+		return nil
+	}
+	exposedPorts := []string{}
+	for exposed := range config.ExposedPorts {
+		exposedPorts = append(exposedPorts, exposed)
+	}
+	sort.Strings(exposedPorts)
+	mappings := portMappings(spec)
+	for _, exposed := range exposedPorts {
+		port, proto, hasProto := strings.Cut(exposed, "/")
+		mapping := port + ":" + port
+		if hasProto {
+			mapping += "/" + proto
+		}
+		m, err := ParsePortMapping(mapping)
+		if err != nil {
+			return err
+		}
+		published := false
+		for _, other := range mappings {
+			if other.ContainerPort == m.ContainerPort && other.Protocol == m.Protocol {
+				published = true
+			}
+		}
+		if !published {
+			mappings = append(mappings, m)
+		}
+	}
+	data, err := json.Marshal(mappings)
+	if err != nil {
+		return err
+	}
+	spec.Annotations[annotationPorts] = string(data)
+	return nil
+}
+
+// resolveImage returns the image of the store with the given name, or
+// whose ID starts with the given prefix.
+func resolveImage(store *imageStore, ref string) (*Image, error) {
+	name := normalizeImageName(ref)
+	for _, img := range store.Images {
+		for _, n := range img.Names {
+			if n == name {
+				return img, nil
+			}
+		}
+	}
+
+	var found *Image
+	prefix := strings.TrimPrefix(ref, digest.Canonical.String()+":")
+	for id, img := range store.Images {
+		if prefix != "" && strings.HasPrefix(id.Encoded(), prefix) {
+			if found != nil {
+				return nil, fmt.Errorf("%w: %s matches several images", ErrImageNotExist, ref)
+			}
+			found = img
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%w: %s", ErrImageNotExist, ref)
+	}
+
+	return found, nil
+}
+
+// readImageConfig reads the configuration of an image from its blob.
+func readImageConfig(img *Image) (*imagespec.Image, error) {
+	data, err := os.ReadFile(blobPath(img.ID))
+	if err != nil {
+		return nil, err
+	}
+	config := &imagespec.Image{}
+	err = json.Unmarshal(data, config)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidImage, err)
+	}
+
+	return config, nil
+}
+
+// imageUsers returns a container using each image, by image ID.
+func imageUsers() (map[digest.Digest]string, error) {
+	infos, err := List()
+	if err != nil {
+		return nil, err
+	}
+	users := map[digest.Digest]string{}
+	for _, info := range infos {
+		id := info.Config.Annotations[annotationImageID]
+		if id != "" {
+			users[digest.Digest(id)] = info.ID
+		}
+	}
+
+	return users, nil
+}
+
+// storeBlob copies a blob into the store unless it is there already, and
+// returns its digest and size. When expected is set, the content must match
+// it.
+func storeBlob(content io.Reader, expected digest.Digest) (digest.Digest, int64, error) {
+	if expected != "" {
+		info, err := os.Stat(blobPath(expected))
+		if err == nil {
+			logrus.Debugf("Blob %s already exists", expected)
+			return expected, info.Size(), nil
+		}
+	}
+
+	algorithm := digest.Canonical
+	if expected != "" {
+		algorithm = expected.Algorithm()
+	}
+	dir := filepath.Join(imageBlobDir, algorithm.String())
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", 0, err
+	}
+	file, err := os.CreateTemp(dir, imageTmpPrefix)
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	digester := algorithm.Digester()
+	size, err := io.Copy(io.MultiWriter(file, digester.Hash()), content)
+	if err != nil {
+		return "", 0, err
+	}
+	if expected != "" && digester.Digest() != expected {
+		return "", 0, fmt.Errorf("%w: digest does not match %s", ErrInvalidImage, expected)
+	}
+	err = file.Chmod(0600)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return digester.Digest(), size, os.Rename(file.Name(), blobPath(digester.Digest()))
+}
+
+// storeLayer stores a layer blob of an archive and unpacks it, unless the
+// store already holds it.
+func storeLayer(src *imageSource, b blob, diffID digest.Digest) (*Layer, error) {
+	err := diffID.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidImage, err)
+	}
+	file, err := src.openBlob(b)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	d, size, err := storeBlob(file, b.digest)
+	if err != nil {
+		return nil, fmt.Errorf("storing %s: %w", b.path, err)
+	}
+
+	layer := &Layer{Digest: d, DiffID: diffID, Size: size}
+	_, err = os.Stat(layerPath(diffID))
+	if err == nil {
+		logrus.Infof("Layer %s already exists", diffID)
+		return layer, nil
+	}
+	logrus.Infof("Unpacking layer %s", diffID)
+	err = unpackLayer(layer)
+	if err != nil {
+		return nil, err
+	}
+
+	return layer, nil
+}
+
+// unpackLayer extracts a stored layer blob into the directory of its diff
+// ID, checking the digest of its uncompressed content.
+func unpackLayer(layer *Layer) error {
+	file, err := os.Open(blobPath(layer.Digest))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	buffered := bufio.NewReader(file)
+	magic, _ := buffered.Peek(4)
+	var content io.Reader = buffered
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return fmt.Errorf("%w: layer %s: %s", ErrInvalidImage, layer.Digest, err)
+		}
+		defer gz.Close()
+		content = gz
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return fmt.Errorf("%w: layer %s is compressed with zstd, which is not supported", ErrInvalidImage, layer.Digest)
+	}
+	digester := layer.DiffID.Algorithm().Digester()
+	content = io.TeeReader(content, digester.Hash())
+
+	err = os.MkdirAll(imageLayerDir, 0711)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(imageLayerDir, imageTmpPrefix)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	err = os.Chmod(tmp, 0755)
+	if err != nil {
+		return err
+	}
+
+	err = applyLayer(tmp, content)
+	if err == nil {
+		// Hash the padding after the end of the archive as well.
+		_, err = io.Copy(io.Discard, content)
+	}
+	if err != nil {
+		return fmt.Errorf("unpacking layer %s: %w", layer.Digest, err)
+	}
+	if digester.Digest() != layer.DiffID {
+		return fmt.Errorf("%w: content of layer %s does not match %s", ErrInvalidImage, layer.Digest, layer.DiffID)
+	}
+
+	return os.Rename(tmp, layerPath(layer.DiffID))
+}
+
+// cleanupImageContent removes the blobs and layers no image of the store
+// refers to, along with what interrupted imports left behind.
+func cleanupImageContent(store *imageStore) error {
+	blobs := map[string]bool{}
+	layers := map[string]bool{}
+	for _, img := range store.Images {
+		blobs[blobPath(img.ID)] = true
+		for _, layer := range img.Layers {
+			blobs[blobPath(layer.Digest)] = true
+			layers[layerPath(layer.DiffID)] = true
+		}
+	}
+
+	dirs := []string{imageLayerDir}
+	algorithms, err := os.ReadDir(imageBlobDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for _, algorithm := range algorithms {
+		dirs = append(dirs, filepath.Join(imageBlobDir, algorithm.Name()))
+	}
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			p := filepath.Join(dir, entry.Name())
+			if blobs[p] || layers[p] {
+				continue
+			}
+			logrus.Debugf("Removing unused %s", p)
+			err = os.RemoveAll(p)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// normalizeImageName appends the latest tag to a name without any.
+func normalizeImageName(name string) string {
+	if name == "" || strings.Contains(name, "@") || strings.Contains(path.Base(name), ":") {
+		return name
+	}
+	return name + ":latest"
+}
+
+// removeName returns names without the given one.
+func removeName(names []string, name string) []string {
+	result := []string{}
+	for _, n := range names {
+		if n != name {
+			result = append(result, n)
+		}
+	}
+	return result
+}
+
+// blobPath returns the path of the blob with the given digest.
+func blobPath(d digest.Digest) string {
+	return filepath.Join(imageBlobDir, d.Algorithm().String(), d.Encoded())
+}
+
+// layerPath returns the directory a layer with the given diff ID is
+// unpacked in.
+func layerPath(diffID digest.Digest) string {
+	return filepath.Join(imageLayerDir, diffID.Encoded())
+}
+
+// updateImages runs fn on the image store while holding its lock, saving
+// the changes fn makes.
+func updateImages(fn func(store *imageStore) error) error {
+	err := os.MkdirAll(imageRoot, 0711)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(imageIndexFile, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	store := &imageStore{}
+	if len(data) > 0 {
+		err = json.Unmarshal(data, store)
+		if err != nil {
+			return err
+		}
+	}
+	if store.Images == nil {
+		store.Images = map[digest.Digest]*Image{}
+	}
+
+	err = fn(store)
+	if err != nil {
+		return err
+	}
+
+	data, err = json.Marshal(store)
+	if err != nil {
+		return err
+	}
+	err = file.Truncate(0)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteAt(data, 0)
+	return err
+}
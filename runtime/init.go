@@ -0,0 +1,124 @@
+package runtime
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"unsafe"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// InitCommand is the hidden command the CLI must dispatch to Init.
+const InitCommand = "init"
+
+// annotationInit marks a spec whose command runs under Init.
+const annotationInit = "org.gophinator.init"
+
+// Init runs the given command as the child of a minimal init process. It
+// reaps every orphan, forwards the signals it receives to the process group
+// of the command, and returns the exit status of the command once it exits.
+func Init(args []string) int {
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		logrus.Errorf("Fail to start init: no command given")
+		return 1
+	}
+
+	signals := make(chan os.Signal, 32)
+	signal.Notify(signals)
+
+	command, err := lookPath(args[0], os.Environ())
+	if err != nil {
+		logrus.Errorf("Fail to find command: %s", err)
+		return 127
+	}
+	sys := &syscall.SysProcAttr{Setpgid: true}
+	sid, err := unix.Getsid(0)
+	if err == nil && sid == os.Getpid() && isTerminal(os.Stdin) {
+		sys.Foreground = true
+		sys.Ctty = 0
+	}
+	pid, err := syscall.ForkExec(command, args, &syscall.ProcAttr{
+		Env:   os.Environ(),
+		Files: []uintptr{0, 1, 2},
+		Sys:   sys,
+	})
+	if err != nil {
+		logrus.Errorf("Fail to exec command: %s", err)
+		return 126
+	}
+
+	for sig := range signals {
+		switch sig {
+		case syscall.SIGCHLD:
+			status, exited := reapChildren(pid)
+			if exited {
+				return exitCode(status)
+			}
+		case syscall.SIGURG:
+			// The Go runtime uses SIGURG for preemption.
+		default:
+			syscall.Kill(-pid, sig.(syscall.Signal))
+		}
+	}
+
+	return 0
+}
+
+// reapChildren reaps every exited child, reporting the status of the given
+// child when it is among them.
+func reapChildren(child int) (syscall.WaitStatus, bool) {
+	var (
+		status syscall.WaitStatus
+		exited bool
+	)
+	for {
+		var stat syscall.WaitStatus
+		pid, err := syscall.Wait4(-1, &stat, syscall.WNOHANG, nil)
+		if err != nil || pid <= 0 {
+			return status, exited
+		}
+		if pid == child {
+			status, exited = stat, true
+		}
+	}
+}
+
+// exitCode returns the exit code a shell would report for the status.
+func exitCode(status syscall.WaitStatus) int {
+	if status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return status.ExitStatus()
+}
+
+// execInit replaces the calling process with Init running the given command,
+// using the gophinator executable opened as exe.
+func execInit(exe *os.File, args []string, env []string) error {
+	argv, err := syscall.SlicePtrFromStrings(append([]string{os.Args[0], InitCommand, "--"}, args...))
+	if err != nil {
+		return err
+	}
+	envv, err := syscall.SlicePtrFromStrings(env)
+	if err != nil {
+		return err
+	}
+	empty, err := syscall.BytePtrFromString("")
+	if err != nil {
+		return err
+	}
+
+	_, _, errno := syscall.Syscall6(
+		unix.SYS_EXECVEAT,
+		exe.Fd(),
+		uintptr(unsafe.Pointer(empty)),
+		uintptr(unsafe.Pointer(&argv[0])),
+		uintptr(unsafe.Pointer(&envv[0])),
+		unix.AT_EMPTY_PATH, 0)
+
+	return errno
+}
@@ -0,0 +1,156 @@
+package runtime
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
+)
+
+// Info is the effective configuration of a container along with what is
+// known about its live process.
+type Info struct {
+	*State
+	Config     *specs.Spec       `json:"config"`
+	Cgroup     string            `json:"cgroup"`
+	Namespaces map[string]string `json:"namespaces,omitempty"`
+	Mounts     []MountInfo       `json:"mounts,omitempty"`
+}
+
+// MountInfo is a mount seen by the container process.
+type MountInfo struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Type        string `json:"type"`
+	Options     string `json:"options"`
+	// Propagation lists the peer groups of the mount, as shared:N for the
+	// one it belongs to and master:N for the one it receives mounts from.
+	Propagation string `json:"propagation,omitempty"`
+}
+
+// List returns the configuration and state of every known container,
+// ordered by creation time.
+func List() ([]*Info, error) {
+	entries, err := os.ReadDir(stateDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []*Info{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	infos := []*Info{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		info, err := loadInfo(entry.Name())
+		if err != nil {
+			logrus.Debugf("Skipping container %s: %s", entry.Name(), err)
+			continue
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Created.Before(infos[j].Created)
+	})
+
+	return infos, nil
+}
+
+// Inspect returns the configuration of the container with the given ID and,
+// while its process is alive, its namespaces and mounts.
+func Inspect(id string) (*Info, error) {
+	info, err := loadInfo(id)
+	if err != nil {
+		return nil, err
+	}
+	if info.Status != specs.StateCreated && info.Status != specs.StateRunning {
+		return info, nil
+	}
+
+	proc := fmt.Sprintf("/proc/%d", info.Pid)
+	info.Namespaces, err = readNamespaces(filepath.Join(proc, "ns"))
+	if err != nil {
+		return nil, err
+	}
+	info.Mounts, err = readMountInfo(filepath.Join(proc, "mountinfo"))
+	if err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// loadInfo reads the state and configuration of a container.
+func loadInfo(id string) (*Info, error) {
+	st, err := ReadState(id)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := loadSpec(statePath(id, specConfigFile))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Info{State: st, Config: spec, Cgroup: cgroupPath(spec, id)}, nil
+}
+
+// readNamespaces returns the namespaces linked in the given directory.
+func readNamespaces(dir string) (map[string]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	namespaces := map[string]string{}
+	for _, entry := range entries {
+		link, err := os.Readlink(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		namespaces[entry.Name()] = link
+	}
+
+	return namespaces, nil
+}
+
+// readMountInfo parses a mountinfo file as described in proc(5).
+func readMountInfo(path string) ([]MountInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	mounts := []MountInfo{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		sep := -1
+		for i, field := range fields {
+			if field == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep < 6 || len(fields) < sep+3 {
+			continue
+		}
+		mounts = append(mounts, MountInfo{
+			Source:      fields[sep+2],
+			Destination: fields[4],
+			Type:        fields[sep+1],
+			Options:     fields[5],
+			Propagation: strings.Join(fields[6:sep], " "),
+		})
+	}
+
+	return mounts, scanner.Err()
+}
@@ -0,0 +1,191 @@
+package runtime
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	whiteoutPrefix     = ".wh."
+	whiteoutOpaque     = ".wh..wh..opq"
+	overlayOpaqueXattr = "trusted.overlay.opaque"
+	xattrPAXPrefix     = "SCHILY.xattr."
+	maxSymlinkFollow   = 255
+)
+
+// applyLayer extracts a layer tarball into the empty directory dir,
+// turning its whiteouts into the ones of overlayfs so that the directory can
+// be stacked on top of the layers below it.
+func applyLayer(dir string, layer io.Reader) error {
+	reader := tar.NewReader(layer)
+	for {
+		hdr, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := path.Clean("/" + hdr.Name)
+		if name == "/" {
+			continue
+		}
+		parent, base := path.Split(name)
+		parentPath, err := resolveInRoot(dir, parent)
+		if err != nil {
+			return err
+		}
+		err = os.MkdirAll(parentPath, 0755)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case base == whiteoutOpaque:
+			err = unix.Lsetxattr(parentPath, overlayOpaqueXattr, []byte("y"), 0)
+		case strings.HasPrefix(base, whiteoutPrefix):
+			target := filepath.Join(parentPath, strings.TrimPrefix(base, whiteoutPrefix))
+			err = os.RemoveAll(target)
+			if err == nil {
+				err = unix.Mknod(target, unix.S_IFCHR, 0)
+			}
+		default:
+			err = extractEntry(dir, filepath.Join(parentPath, base), hdr, reader)
+		}
+		if err != nil {
+			return fmt.Errorf("extracting %s: %w", name, err)
+		}
+	}
+}
+
+// extractEntry creates the file described by a tar header at target.
+func extractEntry(root string, target string, hdr *tar.Header, reader io.Reader) error {
+	info, err := os.Lstat(target)
+	if err == nil && !(info.IsDir() && hdr.Typeflag == tar.TypeDir) {
+		err = os.RemoveAll(target)
+		if err != nil {
+			return err
+		}
+	}
+
+	mode := uint32(hdr.Mode) & 07777
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		err = os.Mkdir(target, 0755)
+		if errors.Is(err, os.ErrExist) {
+			err = nil
+		}
+	case tar.TypeReg:
+		var file *os.File
+		file, err = os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(file, reader)
+		file.Close()
+	case tar.TypeSymlink:
+		err = os.Symlink(hdr.Linkname, target)
+	case tar.TypeLink:
+		dir, base := path.Split(path.Clean("/" + hdr.Linkname))
+		var source string
+		source, err = resolveInRoot(root, dir)
+		if err != nil {
+			return err
+		}
+		err = os.Link(filepath.Join(source, base), target)
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		kind := map[byte]uint32{
+			tar.TypeChar:  unix.S_IFCHR,
+			tar.TypeBlock: unix.S_IFBLK,
+			tar.TypeFifo:  unix.S_IFIFO,
+		}[hdr.Typeflag]
+		err = unix.Mknod(target, kind|mode, int(unix.Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor))))
+		if errors.Is(err, syscall.EPERM) && hdr.Typeflag != tar.TypeFifo {
+			logrus.Warnf("Skipping device %s: %s", hdr.Name, err)
+			return nil
+		}
+	default:
+		logrus.Debugf("Skipping %s of unsupported type %q", hdr.Name, hdr.Typeflag)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Lchown(target, hdr.Uid, hdr.Gid)
+	if err != nil && !errors.Is(err, syscall.EPERM) {
+		return err
+	}
+	for key, value := range hdr.PAXRecords {
+		if strings.HasPrefix(key, xattrPAXPrefix) {
+			err = unix.Lsetxattr(target, strings.TrimPrefix(key, xattrPAXPrefix), []byte(value), 0)
+			if err != nil {
+				logrus.Debugf("Setting extended attribute %s of %s failed: %s", key, hdr.Name, err)
+			}
+		}
+	}
+	if hdr.Typeflag != tar.TypeSymlink {
+		// Changing the owner clears the setuid and setgid bits.
+		err = unix.Chmod(target, mode)
+		if err != nil {
+			return err
+		}
+	}
+	times := []unix.Timespec{unix.NsecToTimespec(hdr.AccessTime.UnixNano()), unix.NsecToTimespec(hdr.ModTime.UnixNano())}
+	if hdr.AccessTime.IsZero() {
+		times[0] = times[1]
+	}
+	return unix.UtimesNanoAt(unix.AT_FDCWD, target, times, unix.AT_SYMLINK_NOFOLLOW)
+}
+
+// resolveInRoot returns the host path of p inside root, following symbolic
+// links as if root were the root directory so that the result never
+// escapes it. Components that do not exist yet are kept as they are.
+func resolveInRoot(root string, p string) (string, error) {
+	current := "/"
+	rest := strings.Split(path.Clean("/"+p), "/")
+	for follows := 0; len(rest) > 0; {
+		component := rest[0]
+		rest = rest[1:]
+		if component == "" {
+			continue
+		}
+		next := path.Join(current, component)
+		info, err := os.Lstat(filepath.Join(root, next))
+		if errors.Is(err, os.ErrNotExist) {
+			return filepath.Join(root, path.Join(append([]string{next}, rest...)...)), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			current = next
+			continue
+		}
+
+		follows++
+		if follows > maxSymlinkFollow {
+			return "", fmt.Errorf("resolving %s: %w", p, syscall.ELOOP)
+		}
+		link, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(link) {
+			current = "/"
+		}
+		rest = append(strings.Split(link, "/"), rest...)
+	}
+
+	return filepath.Join(root, current), nil
+}
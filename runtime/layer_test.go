@@ -0,0 +1,139 @@
+package runtime
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// layerTar returns a layer tarball made of the given headers, regular files
+// holding their name as content.
+func layerTar(t *testing.T, headers []tar.Header) *bytes.Buffer {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	writer := tar.NewWriter(buf)
+	for _, hdr := range headers {
+		var data []byte
+		if hdr.Typeflag == tar.TypeReg {
+			data = []byte(hdr.Name)
+			hdr.Size = int64(len(data))
+		}
+		if hdr.Mode == 0 {
+			hdr.Mode = 0644
+		}
+		err := writer.WriteHeader(&hdr)
+		if err == nil {
+			_, err = writer.Write(data)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func TestApplyLayer(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("creating whiteouts needs root")
+	}
+	dir := t.TempDir()
+	layer := layerTar(t, []tar.Header{
+		{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "etc/hosts", Typeflag: tar.TypeReg},
+		{Name: "etc/.wh.passwd", Typeflag: tar.TypeReg},
+		{Name: "var/lib/.wh..wh..opq", Typeflag: tar.TypeReg},
+		{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc"},
+		{Name: "link/motd", Typeflag: tar.TypeReg},
+		{Name: "../../escape", Typeflag: tar.TypeReg},
+		{Name: "hard", Typeflag: tar.TypeLink, Linkname: "/etc/hosts"},
+	})
+	err := applyLayer(dir, layer)
+	if err != nil {
+		t.Fatalf("applyLayer() error = %v", err)
+	}
+
+	for name, want := range map[string]string{
+		"/etc/hosts": "etc/hosts",
+		"/etc/motd":  "link/motd",
+		"/escape":    "../../escape",
+		"/hard":      "etc/hosts",
+	} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v, want %q", name, data, err, want)
+		}
+	}
+	_, err = os.Lstat(filepath.Join(filepath.Dir(dir), "escape"))
+	if err == nil {
+		t.Errorf("layer escaped its directory")
+	}
+
+	var stat unix.Stat_t
+	err = unix.Lstat(filepath.Join(dir, "/etc/passwd"), &stat)
+	if err != nil || stat.Mode&unix.S_IFMT != unix.S_IFCHR || stat.Rdev != 0 {
+		t.Errorf("/etc/passwd is not a whiteout: %+v, %v", stat, err)
+	}
+	opaque := make([]byte, 1)
+	n, err := unix.Lgetxattr(filepath.Join(dir, "/var/lib"), overlayOpaqueXattr, opaque)
+	if err != nil || n != 1 || opaque[0] != 'y' {
+		t.Errorf("/var/lib is not opaque: %q, %v", opaque[:n], err)
+	}
+}
+
+func TestResolveInRoot(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"/usr/lib", "/etc"} {
+		err := os.MkdirAll(filepath.Join(root, dir), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range map[string]string{
+		"/lib":          "usr/lib",
+		"/abs":          "/usr/lib",
+		"/up":           "../../..",
+		"/etc/loop":     "/etc/loop",
+		"/usr/lib/self": ".",
+	} {
+		err := os.Symlink(target, filepath.Join(root, link))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		in   string
+		want string
+		err  bool
+	}{
+		{"/", "/", false},
+		{"/etc", "/etc", false},
+		{"/lib/x", "/usr/lib/x", false},
+		{"/abs/self/self", "/usr/lib", false},
+		{"/up/etc", "/etc", false},
+		{"../../etc", "/etc", false},
+		{"/lib/missing/x", "/usr/lib/missing/x", false},
+		{"/etc/loop/x", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := resolveInRoot(root, tt.in)
+		if tt.err {
+			if err == nil {
+				t.Errorf("resolveInRoot(%q) = %q, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != filepath.Join(root, tt.want) {
+			t.Errorf("resolveInRoot(%q) = %q, %v, want %q", tt.in, got, err, filepath.Join(root, tt.want))
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package runtime
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	seccomp "github.com/seccomp/libseccomp-golang"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	annotationSeccompLearn = "org.gophinator.seccomp.learn"
+	learnListenerLink      = "anon_inode:seccomp notify"
+	learnPollInterval      = time.Millisecond
+	learnPollTimeout       = 100 * time.Millisecond
+)
+
+// seccompLearner records the syscalls made by a container whose seccomp
+// filter notifies every one of them, letting them run.
+type seccompLearner struct {
+	pid  uintptr
+	done chan struct{}
+	wg   sync.WaitGroup
+
+	mu sync.Mutex
+	// syscalls maps the names of the syscalls learned to the architectures
+	// they were made from.
+	syscalls map[string][]specs.Arch
+	err      error
+}
+
+// setupSyscallLearning sets up the seccomp filter of a container run to
+// learn its syscalls, notifying all of them to the learner of the runtime.
+// The syscalls the runtime makes until it executes the command are
+// recorded as well.
+func setupSyscallLearning() error {
+	filter, err := seccomp.NewFilter(seccomp.ActNotify)
+	if err != nil {
+		return err
+	}
+	for _, arch := range []seccomp.ScmpArch{seccomp.ArchX86, seccomp.ArchX32} {
+		err = filter.AddArch(arch)
+		if err != nil {
+			logrus.Debugf("Fail to add seccomp architecture %s: %s", arch, err)
+		}
+	}
+
+	return filter.Load()
+}
+
+// startSeccompLearner starts recording the syscalls of the container with
+// the given PID once its filter is loaded.
+func startSeccompLearner(pid uintptr) *seccompLearner {
+	l := &seccompLearner{
+		pid:      pid,
+		done:     make(chan struct{}),
+		syscalls: map[string][]specs.Arch{},
+	}
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		err := l.run()
+		if err != nil {
+			// The container would otherwise wait forever for its syscalls
+			// to be let through.
+			logrus.Errorf("Fail to learn syscalls: %s", err)
+			syscall.Kill(int(pid), syscall.SIGKILL)
+			l.mu.Lock()
+			l.err = err
+			l.mu.Unlock()
+		}
+	}()
+
+	return l
+}
+
+// run lets the syscalls of the container through until all of its processes
+// have exited or the learner is stopped.
+func (l *seccompLearner) run() error {
+	fd, err := l.listener()
+	if err != nil || fd < 0 {
+		return err
+	}
+	defer syscall.Close(fd)
+	logrus.Debugf("Learning syscalls of PID %d", l.pid)
+
+	for {
+		fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+		n, err := unix.Poll(fds, int(learnPollTimeout/time.Millisecond))
+		if errors.Is(err, syscall.EINTR) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if fds[0].Revents&unix.POLLHUP != 0 {
+			return nil
+		}
+		if n == 0 {
+			select {
+			case <-l.done:
+				return nil
+			default:
+				continue
+			}
+		}
+
+		req, err := seccomp.NotifReceive(seccomp.ScmpFd(fd))
+		if err != nil {
+			// The process making the syscall may have been killed.
+			logrus.Debugf("Receiving seccomp notification failed: %s", err)
+			continue
+		}
+		l.record(req.Data.Syscall, req.Data.Arch)
+		resp := &seccomp.ScmpNotifResp{ID: req.ID, Flags: seccomp.NotifRespFlagContinue}
+		err = seccomp.NotifRespond(seccomp.ScmpFd(fd), resp)
+		if err != nil && seccomp.NotifIDValid(seccomp.ScmpFd(fd), req.ID) == nil {
+			return fmt.Errorf("letting %s through: %w", l.name(req.Data.Syscall, req.Data.Arch), err)
+		}
+	}
+}
+
+// listener waits for the container to load its filter and returns a copy
+// of the descriptor its notifications are received from, or -1 when the
+// learner is stopped first.
+func (l *seccompLearner) listener() (int, error) {
+	pidfd, err := unix.PidfdOpen(int(l.pid), 0)
+	if err != nil {
+		return -1, err
+	}
+	defer syscall.Close(pidfd)
+
+	dir := fmt.Sprintf("/proc/%d/fd", l.pid)
+	for {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return -1, err
+		}
+		for _, entry := range entries {
+			link, err := os.Readlink(filepath.Join(dir, entry.Name()))
+			if err != nil || link != learnListenerLink {
+				continue
+			}
+			target, err := strconv.Atoi(entry.Name())
+			if err != nil {
+				continue
+			}
+			return unix.PidfdGetfd(pidfd, target, 0)
+		}
+
+		select {
+		case <-l.done:
+			return -1, nil
+		case <-time.After(learnPollInterval):
+		}
+	}
+}
+
+// record adds a syscall to the ones learned.
+func (l *seccompLearner) record(call seccomp.ScmpSyscall, arch seccomp.ScmpArch) {
+	var arches = map[seccomp.ScmpArch]specs.Arch{
+		seccomp.ArchAMD64: specs.ArchX86_64,
+		seccomp.ArchX86:   specs.ArchX86,
+		seccomp.ArchX32:   specs.ArchX32,
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	name := l.name(call, arch)
+	a, ok := arches[arch]
+	if !ok {
+		a = specs.Arch("SCMP_ARCH_" + strings.ToUpper(arch.String()))
+	}
+	if !slices.Contains(l.syscalls[name], a) {
+		l.syscalls[name] = append(l.syscalls[name], a)
+	}
+}
+
+// name returns the name of a syscall, or its number when it is unknown.
+func (l *seccompLearner) name(call seccomp.ScmpSyscall, arch seccomp.ScmpArch) string {
+	name, err := call.GetNameByArch(arch)
+	if err != nil {
+		return strconv.Itoa(int(call))
+	}
+	return name
+}
+
+// stop stops recording and writes to path a profile allowing only the
+// syscalls learned, for the architecture of the host and the ones of its
+// other ABIs the container used.
+func (l *seccompLearner) stop(path string) error {
+	close(l.done)
+	l.wg.Wait()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		return l.err
+	}
+	names := []string{}
+	subArches := []specs.Arch{}
+	for name, arches := range l.syscalls {
+		for _, arch := range arches {
+			if arch != seccompNativeScmpArch && !slices.Contains(subArches, arch) {
+				subArches = append(subArches, arch)
+			}
+		}
+		if _, err := strconv.Atoi(name); err == nil {
+			logrus.Warnf("Fail to name syscall %s, leaving it out of the profile", name)
+			continue
+		}
+		// The rules of a profile name the syscalls of every architecture
+		// it filters at once, so those the host does not have only apply
+		// where the architecture they were made from is filtered too.
+		call, err := seccomp.GetSyscallFromNameByArch(name, seccomp.ArchNative)
+		if err != nil || call < 0 {
+			logrus.Warnf("Fail to resolve syscall %s on %s, allowing it only for %s", name, seccompNativeScmpArch, joinArches(arches))
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	sort.Slice(subArches, func(i, j int) bool {
+		return subArches[i] < subArches[j]
+	})
+
+	errnoRet := uint(syscall.EPERM)
+	profile := &seccompProfile{
+		DefaultAction:   specs.ActErrno,
+		DefaultErrnoRet: &errnoRet,
+		ArchMap:         []seccompArchMap{{Arch: seccompNativeScmpArch, SubArches: subArches}},
+		Syscalls: []seccompSyscall{
+			{LinuxSyscall: specs.LinuxSyscall{Names: names, Action: specs.ActAllow}},
+		},
+	}
+	data, err := json.MarshalIndent(profile, "", "\t")
+	if err != nil {
+		return err
+	}
+	logrus.Debugf("Learning %d syscalls", len(names))
+
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// joinArches returns the given architectures as a comma-separated list.
+func joinArches(arches []specs.Arch) string {
+	names := []string{}
+	for _, arch := range arches {
+		names = append(names, string(arch))
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,38 @@
+package runtime
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func TestSeccompLearnerStop(t *testing.T) {
+	l := &seccompLearner{
+		done: make(chan struct{}),
+		syscalls: map[string][]specs.Arch{
+			"read":       {specs.ArchX86_64, specs.ArchX86},
+			"socketcall": {specs.ArchX86},
+			"999":        {specs.ArchX86_64},
+		},
+	}
+	path := filepath.Join(t.TempDir(), "seccomp.json")
+	err := l.stop(path)
+	if err != nil {
+		t.Fatalf("stop() error = %v", err)
+	}
+
+	seccomp, err := loadSeccomp(path, nil)
+	if err != nil {
+		t.Fatalf("loadSeccomp() error = %v", err)
+	}
+	wantArches := []specs.Arch{specs.ArchX86_64, specs.ArchX86}
+	if !reflect.DeepEqual(seccomp.Architectures, wantArches) {
+		t.Errorf("Architectures = %v, want %v", seccomp.Architectures, wantArches)
+	}
+	wantNames := []string{"read", "socketcall"}
+	if got := seccompNames(seccomp); !reflect.DeepEqual(got, wantNames) {
+		t.Errorf("syscalls = %q, want %q", got, wantNames)
+	}
+}
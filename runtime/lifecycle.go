@@ -0,0 +1,338 @@
+package runtime
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
+)
+
+// ShimCommand is the hidden command the CLI must dispatch to Shim.
+const ShimCommand = "shim"
+
+const (
+	shimNotifyFd      = 3
+	shimCreateFail    = 0x0
+	shimCreateSuccess = 0x1
+	shimStopTimeout   = 5 * time.Second
+	shimPollInterval  = 50 * time.Millisecond
+)
+
+// Create persists the container in the state directory and hands it over to
+// a detached shim, returning once the container is waiting to be started.
+func (r *Runtime) Create() error {
+	_, err := r.register()
+	if err != nil {
+		return err
+	}
+	err = syscall.Mkfifo(statePath(r.id, stateExecFifo), 0600)
+	if err != nil {
+		return err
+	}
+
+	return spawnShim(r.id)
+}
+
+// register creates the state directory of the container and records it as
+// being created.
+func (r *Runtime) register() (*State, error) {
+	dir := statePath(r.id, "")
+	_, err := os.Stat(dir)
+	if err == nil {
+		return nil, ErrContainerExists
+	}
+	err = os.MkdirAll(dir, 0711)
+	if err != nil {
+		return nil, err
+	}
+	logrus.Debugf("Creating state directory %s", dir)
+
+	data, err := json.Marshal(r.spec)
+	if err != nil {
+		return nil, err
+	}
+	err = os.WriteFile(statePath(r.id, specConfigFile), data, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	volumes := []VolumePair{}
+	for i := range r.spec.Mounts {
+		m := &r.spec.Mounts[i]
+		if isBindMount(m) {
+			volumes = append(volumes, VolumePair{Source: m.Source, Target: m.Destination, Options: m.Options})
+		}
+	}
+	upper := ""
+	if r.spec.Annotations[annotationOverlay] == "true" {
+		upper = filepath.Join(filesysPrefix+r.uuid, filesysUpper)
+	}
+	st := &State{
+		State: specs.State{
+			Version:     specs.Version,
+			ID:          r.id,
+			Status:      specs.StateCreating,
+			Bundle:      r.bundle,
+			Annotations: r.spec.Annotations,
+		},
+		Hostname: r.hostname,
+		UUID:     r.uuid,
+		Root:     r.spec.Root.Path,
+		Image:    r.spec.Annotations[annotationImage],
+		UpperDir: upper,
+		Volumes:  volumes,
+		Network:  networkMode(r.spec),
+		Ports:    portMappings(r.spec),
+		Created:  time.Now(),
+	}
+	err = writeState(st)
+	if err != nil {
+		return nil, err
+	}
+
+	return st, nil
+}
+
+// spawnShim starts a detached shim for the given container and waits for it
+// to report the outcome of the creation.
+func spawnShim(id string) error {
+	sockets, err := newSocketPair()
+	if err != nil {
+		return err
+	}
+	defer cleanupSocketPair(sockets)
+
+	args := []string{ShimCommand, id}
+	if logrus.IsLevelEnabled(logrus.DebugLevel) {
+		args = append([]string{"--debug"}, args...)
+	}
+	// The shim and the container write to a log of their own rather than
+	// holding the terminal or the pipe of the caller, and read nothing.
+	log, err := os.OpenFile(statePath(id, stateLogFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer log.Close()
+	cmd := exec.Command("/proc/self/exe", args...)
+	cmd.Stdout = log
+	cmd.Stderr = log
+	cmd.ExtraFiles = []*os.File{os.NewFile(uintptr(sockets[1]), "shim")}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+	logrus.Debugf("Spawning shim with PID %d", cmd.Process.Pid)
+	err = syscall.Close(sockets[1])
+	if err != nil {
+		return err
+	}
+	sockets[1] = -1
+	defer cmd.Process.Release()
+
+	recv := make([]byte, 4096)
+	n, _, err := syscall.Recvfrom(sockets[0], recv, 0)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrShimExited
+	}
+	if recv[0] == shimCreateFail {
+		return errors.New(string(recv[1:n]))
+	}
+
+	return nil
+}
+
+// Shim creates the container with the given ID, waits for it to be started
+// and records its state until it exits. It is run in a detached process
+// spawned by Create.
+func Shim(id string) error {
+	syscall.CloseOnExec(shimNotifyFd)
+	notify := func(cause error) {
+		msg := []byte{shimCreateSuccess}
+		if cause != nil {
+			msg = append([]byte{shimCreateFail}, cause.Error()...)
+		}
+		err := syscall.Sendto(shimNotifyFd, msg, 0, nil)
+		if err != nil {
+			logrus.Errorf("Fail to notify creation: %s", err)
+		}
+		syscall.Close(shimNotifyFd)
+	}
+
+	st, err := readState(id)
+	if err != nil {
+		notify(err)
+		return err
+	}
+	spec, err := loadSpec(statePath(id, specConfigFile))
+	if err != nil {
+		notify(err)
+		return err
+	}
+	r, err := newRuntime(id, st.Bundle, spec)
+	if err != nil {
+		notify(err)
+		return err
+	}
+	r.hostname = st.Hostname
+	r.uuid = st.UUID
+
+	defer r.cleanup()
+	err = r.spawn()
+	if err != nil {
+		r.kill()
+		markStopped(st, -1)
+		notify(err)
+		return err
+	}
+	// The fifo is opened before the creation is notified so that Start
+	// finds a reader, and for writing as well so that reading waits for
+	// Start instead of seeing the end of the file.
+	fifo, err := os.OpenFile(statePath(id, stateExecFifo), os.O_RDWR, 0)
+	if err == nil {
+		err = markCreated(st, r)
+	}
+	notify(err)
+	if err != nil {
+		if fifo != nil {
+			fifo.Close()
+		}
+		r.kill()
+		markStopped(st, -1)
+		return err
+	}
+	logrus.Infof("Container %s created with PID %d", id, r.pid)
+
+	_, err = fifo.Read(make([]byte, 1))
+	fifo.Close()
+	os.Remove(statePath(id, stateExecFifo))
+	if err != nil {
+		r.kill()
+		markStopped(st, -1)
+		return err
+	}
+
+	err = r.release()
+	if err != nil {
+		logrus.Debugf("Releasing container failed: %s", err)
+	}
+	markRunning(st)
+
+	stat, err := waitChild(r.pid)
+	if err != nil {
+		markStopped(st, -1)
+		return err
+	}
+	logrus.Infof("Container %s exited with status %d", id, stat)
+	markStopped(st, stat)
+
+	return nil
+}
+
+// Start executes the user command of a created container.
+func Start(id string) error {
+	st, err := ReadState(id)
+	if err != nil {
+		return err
+	}
+	if st.Status != specs.StateCreated {
+		return ErrContainerNotCreated
+	}
+
+	fifo, err := os.OpenFile(statePath(id, stateExecFifo), os.O_WRONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return err
+	}
+	defer fifo.Close()
+	_, err = fifo.Write([]byte{0x0})
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(statePath(id, stateExecFifo))
+}
+
+// Kill sends the given signal to the process of a created or running container.
+func Kill(id string, sig syscall.Signal) error {
+	st, err := ReadState(id)
+	if err != nil {
+		return err
+	}
+	if st.Status != specs.StateCreated && st.Status != specs.StateRunning {
+		return ErrContainerNotRunning
+	}
+
+	return syscall.Kill(st.Pid, sig)
+}
+
+// Delete removes a container and its state. A running container is only
+// deleted when force is set, in which case it is killed first.
+func Delete(id string, force bool) error {
+	st, err := ReadState(id)
+	if err != nil {
+		return err
+	}
+
+	switch st.Status {
+	case specs.StateRunning:
+		if !force {
+			return ErrContainerNotStopped
+		}
+		fallthrough
+	case specs.StateCreated:
+		err = syscall.Kill(st.Pid, syscall.SIGKILL)
+		if err != nil && !errors.Is(err, syscall.ESRCH) {
+			return err
+		}
+		wakeShim(id)
+		err = waitStopped(id)
+		if err != nil {
+			return err
+		}
+	case specs.StateCreating:
+		if !force {
+			return ErrContainerNotStopped
+		}
+	case specs.StateStopped:
+	}
+
+	cleanupFilesys(st.UUID, false)
+	logrus.Debugf("Removing state directory of container %s", id)
+	return os.RemoveAll(statePath(id, ""))
+}
+
+// wakeShim unblocks a shim still waiting for its container to be started.
+func wakeShim(id string) {
+	fifo, err := os.OpenFile(statePath(id, stateExecFifo), os.O_WRONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return
+	}
+	defer fifo.Close()
+	fifo.Write([]byte{0x0})
+}
+
+// waitStopped waits for the container to stop after it has been killed.
+func waitStopped(id string) error {
+	deadline := time.Now().Add(shimStopTimeout)
+	for time.Now().Before(deadline) {
+		st, err := ReadState(id)
+		if err != nil {
+			return err
+		}
+		if st.Status == specs.StateStopped {
+			return nil
+		}
+		time.Sleep(shimPollInterval)
+	}
+
+	return ErrContainerNotStopped
+}
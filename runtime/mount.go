@@ -0,0 +1,272 @@
+package runtime
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/go-units"
+	"github.com/google/uuid"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+const volumeDir = imageRoot + "/volumes"
+
+const (
+	MountBind   = "bind"
+	MountTmpfs  = "tmpfs"
+	MountVolume = "volume"
+	MountProc   = "proc"
+	MountSysfs  = "sysfs"
+	MountDevpts = "devpts"
+)
+
+// Mount is a filesystem mounted into a container from the command line.
+type Mount struct {
+	// Type is one of MountBind, MountTmpfs, MountVolume, MountProc and
+	// MountSysfs.
+	Type string `json:"type"`
+	// Source is the host path of a bind mount or the name of a volume,
+	// a volume without a name getting a random one.
+	Source string `json:"source,omitempty"`
+	// Target is the path of the mount in the container.
+	Target   string `json:"target"`
+	ReadOnly bool   `json:"readOnly,omitempty"`
+	// Size and Mode are the size in bytes and the permissions of a tmpfs.
+	Size int64  `json:"size,omitempty"`
+	Mode uint32 `json:"mode,omitempty"`
+	// Options are nosuid, nodev, noexec and, for bind mounts and volumes,
+	// a propagation.
+	Options []string `json:"options,omitempty"`
+}
+
+// ParseMount parses a mount given as comma separated key=value pairs, with
+// the keys type, src, dst, size, mode and propagation, and the flags ro, rw,
+// nosuid, nodev and noexec. Fields may be quoted as in CSV so that paths can
+// hold commas.
+func ParseMount(s string) (Mount, error) {
+	fields, err := csv.NewReader(strings.NewReader(s)).Read()
+	if err != nil {
+		return Mount{}, fmt.Errorf("%w: %q: %s", ErrInvalidMount, s, err)
+	}
+
+	m := Mount{}
+	for _, field := range fields {
+		err = m.setOption(strings.TrimSpace(field))
+		if err != nil {
+			return Mount{}, err
+		}
+	}
+
+	return m, m.validate()
+}
+
+// ParseTmpfs parses a tmpfs mount in the form target[:options], where
+// options is a comma separated list of size, mode and the flags ro, rw,
+// nosuid, nodev and noexec.
+func ParseTmpfs(s string) (Mount, error) {
+	target, options, _ := strings.Cut(s, ":")
+	if !path.IsAbs(target) {
+		return Mount{}, fmt.Errorf("%w: %q is not absolute", ErrInvalidMount, target)
+	}
+
+	m := Mount{Type: MountTmpfs, Target: target}
+	for _, option := range strings.Split(options, ",") {
+		key, _, _ := strings.Cut(option, "=")
+		switch key {
+		case "":
+			continue
+		case "type", "source", "src", "destination", "dst", "target", "propagation", "bind-propagation":
+			return Mount{}, fmt.Errorf("%w: %q: unknown option", ErrInvalidMount, option)
+		}
+		err := m.setOption(option)
+		if err != nil {
+			return Mount{}, err
+		}
+	}
+
+	return m, m.validate()
+}
+
+// setOption sets the field of the mount given as key=value, or the flag
+// given alone.
+func (m *Mount) setOption(field string) error {
+	var err error
+	key, value, hasValue := strings.Cut(field, "=")
+	switch key {
+	case "type":
+		m.Type = value
+	case "source", "src":
+		m.Source = value
+	case "destination", "dst", "target":
+		m.Target = value
+	case "readonly", "ro":
+		m.ReadOnly = true
+		if hasValue {
+			m.ReadOnly, err = strconv.ParseBool(value)
+		}
+	case "rw":
+		m.ReadOnly = false
+	case "size", "tmpfs-size":
+		m.Size, err = units.RAMInBytes(value)
+	case "mode", "tmpfs-mode":
+		var mode uint64
+		mode, err = strconv.ParseUint(value, 8, 32)
+		m.Mode = uint32(mode)
+	case "propagation", "bind-propagation":
+		m.Options = append(m.Options, value)
+	case "nosuid", "nodev", "noexec":
+		if hasValue {
+			err = fmt.Errorf("%s takes no value", key)
+		}
+		m.Options = append(m.Options, key)
+	default:
+		err = fmt.Errorf("unknown key %q", key)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %q: %s", ErrInvalidMount, field, err)
+	}
+
+	return nil
+}
+
+// validate checks that the mount has what its type needs and nothing else.
+func (m Mount) validate() error {
+	var sources = map[string]bool{
+		MountBind:   true,
+		MountTmpfs:  false,
+		MountVolume: true,
+		MountProc:   false,
+		MountSysfs:  false,
+	}
+	hasSource, ok := sources[m.Type]
+	switch {
+	case !ok:
+		return fmt.Errorf("%w: unknown type %q", ErrInvalidMount, m.Type)
+	case m.Target == "":
+		return fmt.Errorf("%w: no destination", ErrInvalidMount)
+	case m.Type == MountBind && m.Source == "":
+		return fmt.Errorf("%w: bind mount has no source", ErrInvalidMount)
+	case !hasSource && m.Source != "":
+		return fmt.Errorf("%w: %s mount takes no source", ErrInvalidMount, m.Type)
+	case m.Type == MountVolume && m.Source != "" && !regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`).MatchString(m.Source):
+		return fmt.Errorf("%w: invalid volume name %q", ErrInvalidMount, m.Source)
+	case m.Type != MountTmpfs && (m.Size != 0 || m.Mode != 0):
+		return fmt.Errorf("%w: only tmpfs mounts take a size and a mode", ErrInvalidMount)
+	case m.Size < 0:
+		return fmt.Errorf("%w: negative size", ErrInvalidMount)
+	}
+
+	_, propagation := splitPropagation(m.Options)
+	if propagation != 0 && !hasSource {
+		return fmt.Errorf("%w: %s mount takes no propagation", ErrInvalidMount, m.Type)
+	}
+	return validateOptions(m.Options, ErrInvalidMount)
+}
+
+// specMount returns the mount of the spec performing the mount, creating
+// the directory of a volume first.
+func (m Mount) specMount() (specs.Mount, error) {
+	sm := specs.Mount{Destination: path.Clean("/" + m.Target), Type: m.Type, Source: m.Type}
+	switch m.Type {
+	case MountBind, MountVolume:
+		sm.Type, sm.Source = MountBind, m.Source
+		if m.Type == MountVolume {
+			name := m.Source
+			if name == "" {
+				name = uuid.NewString()
+			}
+			sm.Source = filepath.Join(volumeDir, name)
+			err := os.MkdirAll(sm.Source, 0755)
+			if err != nil {
+				return sm, err
+			}
+		}
+		sm.Options = VolumePair{Options: m.Options}.mountOptions()
+	case MountTmpfs:
+		sm.Options = append([]string{"nosuid", "nodev"}, m.Options...)
+		if m.Size > 0 {
+			sm.Options = append(sm.Options, fmt.Sprintf("size=%d", m.Size))
+		}
+		if m.Mode != 0 {
+			sm.Options = append(sm.Options, fmt.Sprintf("mode=%o", m.Mode))
+		}
+	default:
+		sm.Options = append([]string{"nosuid", "nodev", "noexec"}, m.Options...)
+	}
+	if m.ReadOnly {
+		sm.Options = append(sm.Options, "ro")
+	}
+
+	return sm, nil
+}
+
+// systemMounts returns the kernel filesystems mounted in every container
+// started from the command line: a tmpfs holding its devices and, unless
+// disabled, a procfs of its PID namespace, a read-only sysfs, a devpts
+// instance of its own and a tmpfs for shared memory.
+func systemMounts(opts *Options) []specs.Mount {
+	mounts := []specs.Mount{{
+		Destination: "/dev",
+		Type:        MountTmpfs,
+		Source:      MountTmpfs,
+		Options:     []string{"nosuid", "strictatime", "mode=755", "size=65536k"},
+	}}
+	if !opts.NoProc {
+		mounts = append(mounts, specs.Mount{
+			Destination: "/proc",
+			Type:        MountProc,
+			Source:      MountProc,
+			Options:     []string{"nosuid", "noexec", "nodev"},
+		})
+	}
+	if !opts.NoSysfs {
+		mounts = append(mounts, specs.Mount{
+			Destination: "/sys",
+			Type:        MountSysfs,
+			Source:      MountSysfs,
+			Options:     []string{"nosuid", "noexec", "nodev", "ro"},
+		})
+	}
+	if !opts.NoDevpts {
+		mounts = append(mounts, specs.Mount{
+			Destination: "/dev/pts",
+			Type:        MountDevpts,
+			Source:      MountDevpts,
+			Options:     []string{"nosuid", "noexec", "newinstance", "ptmxmode=0666", "mode=0620"},
+		})
+	}
+	if !opts.NoShm {
+		mounts = append(mounts, specs.Mount{
+			Destination: "/dev/shm",
+			Type:        MountTmpfs,
+			Source:      "shm",
+			Options:     []string{"nosuid", "noexec", "nodev", "mode=1777", "size=65536k"},
+		})
+	}
+
+	return mounts
+}
+
+// sortMounts orders mounts so that every mount comes after the ones of the
+// directories above its destination, keeping the given order otherwise.
+func sortMounts(mounts []specs.Mount) {
+	sort.SliceStable(mounts, func(i, j int) bool {
+		return mountDepth(mounts[i]) < mountDepth(mounts[j])
+	})
+}
+
+// mountDepth returns the number of components of the destination of m.
+func mountDepth(m specs.Mount) int {
+	dest := path.Clean("/" + m.Destination)
+	if dest == "/" {
+		return 0
+	}
+	return strings.Count(dest, "/")
+}
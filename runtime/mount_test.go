@@ -0,0 +1,117 @@
+package runtime
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func TestParseMount(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Mount
+		err  error
+	}{
+		{"type=bind,src=/a,dst=/b", Mount{Type: MountBind, Source: "/a", Target: "/b"}, nil},
+		{"type=bind,source=/a,target=/b,readonly,bind-propagation=rslave", Mount{Type: MountBind, Source: "/a", Target: "/b", ReadOnly: true, Options: []string{"rslave"}}, nil},
+		{`type=bind,"src=/a,b",dst=/c`, Mount{Type: MountBind, Source: "/a,b", Target: "/c"}, nil},
+		{" type=bind , src=/a , dst=/b ", Mount{Type: MountBind, Source: "/a", Target: "/b"}, nil},
+		{"type=volume,dst=/data", Mount{Type: MountVolume, Target: "/data"}, nil},
+		{"type=volume,src=data,destination=/data,ro=false,nosuid", Mount{Type: MountVolume, Source: "data", Target: "/data", Options: []string{"nosuid"}}, nil},
+		{"type=tmpfs,dst=/t,tmpfs-size=1m,tmpfs-mode=700", Mount{Type: MountTmpfs, Target: "/t", Size: 1 << 20, Mode: 0700}, nil},
+		{"type=proc,dst=/p,ro", Mount{Type: MountProc, Target: "/p", ReadOnly: true}, nil},
+		{`type=bind,"src=/a`, Mount{}, ErrInvalidMount},
+		{"dst=/b", Mount{}, ErrInvalidMount},
+		{"type=nfs,dst=/b", Mount{}, ErrInvalidMount},
+		{"type=bind,src=/a", Mount{}, ErrInvalidMount},
+		{"type=bind,dst=/b", Mount{}, ErrInvalidMount},
+		{"type=tmpfs,src=/a,dst=/b", Mount{}, ErrInvalidMount},
+		{"type=volume,src=../x,dst=/d", Mount{}, ErrInvalidMount},
+		{"type=bind,src=/a,dst=/b,size=1m", Mount{}, ErrInvalidMount},
+		{"type=tmpfs,dst=/b,size=-1", Mount{}, ErrInvalidMount},
+		{"type=bind,src=/a,dst=/b,readonly=maybe", Mount{}, ErrInvalidMount},
+		{"type=bind,src=/a,dst=/b,propagation=bogus", Mount{}, ErrInvalidMount},
+		{"type=bind,src=/a,dst=/b,propagation=shared,propagation=slave", Mount{}, ErrInvalidMount},
+		{"type=tmpfs,dst=/b,propagation=shared", Mount{}, ErrInvalidMount},
+		{"type=bind,src=/a,dst=/b,foo=1", Mount{}, ErrInvalidMount},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseMount(tt.in)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) || errors.Is(err, ErrInvalidVolume) {
+				t.Errorf("ParseMount(%q) error = %v, want %v", tt.in, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseMount(%q) error = %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseMount(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseTmpfs(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Mount
+		err  error
+	}{
+		{"/run", Mount{Type: MountTmpfs, Target: "/run"}, nil},
+		{"/run:", Mount{Type: MountTmpfs, Target: "/run"}, nil},
+		{"/run:size=64m,mode=1777", Mount{Type: MountTmpfs, Target: "/run", Size: 64 << 20, Mode: 01777}, nil},
+		{"/run:ro,noexec,nosuid", Mount{Type: MountTmpfs, Target: "/run", ReadOnly: true, Options: []string{"noexec", "nosuid"}}, nil},
+		{"/run:ro,rw", Mount{Type: MountTmpfs, Target: "/run"}, nil},
+		{"run", Mount{}, ErrInvalidMount},
+		{"/run:size=big", Mount{}, ErrInvalidMount},
+		{"/run:mode=999", Mount{}, ErrInvalidMount},
+		{"/run:bogus", Mount{}, ErrInvalidMount},
+		{"/run:noexec=1", Mount{}, ErrInvalidMount},
+		{"/run:src=/tmp", Mount{}, ErrInvalidMount},
+		{"/run:type=bind", Mount{}, ErrInvalidMount},
+		{"/run:propagation=shared", Mount{}, ErrInvalidMount},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseTmpfs(tt.in)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) || errors.Is(err, ErrInvalidVolume) {
+				t.Errorf("ParseTmpfs(%q) error = %v, want %v", tt.in, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseTmpfs(%q) error = %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseTmpfs(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSortMounts(t *testing.T) {
+	mounts := []specs.Mount{
+		{Destination: "/a/b/c"},
+		{Destination: "/a"},
+		{Destination: "/x/y"},
+		{Destination: "/a/b"},
+		{Destination: "/"},
+		{Destination: "/b"},
+	}
+	want := []string{"/", "/a", "/b", "/x/y", "/a/b", "/a/b/c"}
+
+	sortMounts(mounts)
+	got := []string{}
+	for _, m := range mounts {
+		got = append(got, m.Destination)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sortMounts() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,476 @@
+package runtime
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	networkBridge     = "gophinator0"
+	networkSubnet     = "10.99.0.0/16"
+	networkSubnet6    = "fd99:6770:6869::/64"
+	networkInterface  = "eth0"
+	networkIPAMFile   = "/run/gophinator-ipam.json"
+	annotationNetwork = "org.gophinator.network"
+
+	NetworkNone   = "none"
+	NetworkHost   = "host"
+	NetworkBridge = "bridge"
+)
+
+// networkMode returns the network mode of a container, which is none for
+// bundles creating a network namespace and host for those that do not.
+func networkMode(spec *specs.Spec) string {
+	if !hasNamespace(spec.Linux.Namespaces, specs.NetworkNamespace) {
+		return NetworkHost
+	}
+	if spec.Annotations[annotationNetwork] == NetworkBridge {
+		return NetworkBridge
+	}
+	return NetworkNone
+}
+
+// network is the bridge network of a container.
+type network struct {
+	id       string
+	hostVeth string
+	ipv4     *net.IPNet
+	ipv6     *net.IPNet
+}
+
+// ipamStore records the IPv4 addresses allocated to containers, along with
+// the forwarding sysctls of the host as they were before the bridge.
+type ipamStore struct {
+	Allocations map[string]string `json:"allocations"`
+	Sysctls     map[string]string `json:"sysctls,omitempty"`
+}
+
+// setupNetwork connects the network namespace of the process with the given
+// PID to the host bridge through a veth pair, with an address allocated to
+// the container and a default route through the bridge.
+func setupNetwork(id string, uuid string, pid uintptr) (*network, error) {
+	n := &network{id: id, hostVeth: "veth" + uuid[:8]}
+	peer := "ceth" + uuid[:8]
+
+	err := updateIPAM(func(store *ipamStore) error {
+		err := setupBridge(store)
+		if err != nil {
+			return err
+		}
+		n.ipv4, n.ipv6, err = allocateIP(store, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	veth := &netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{Name: n.hostVeth},
+		PeerName:  peer,
+	}
+	err = netlink.LinkAdd(veth)
+	if err != nil {
+		n.teardown()
+		return nil, err
+	}
+	host, err := netlink.LinkByName(n.hostVeth)
+	if err != nil {
+		n.teardown()
+		return nil, err
+	}
+	bridge, err := netlink.LinkByName(networkBridge)
+	if err != nil {
+		n.teardown()
+		return nil, err
+	}
+	err = netlink.LinkSetMaster(host, bridge)
+	if err == nil {
+		err = netlink.LinkSetUp(host)
+	}
+	if err != nil {
+		n.teardown()
+		return nil, err
+	}
+	link, err := netlink.LinkByName(peer)
+	if err == nil {
+		err = netlink.LinkSetNsPid(link, int(pid))
+	}
+	if err != nil {
+		n.teardown()
+		return nil, err
+	}
+
+	err = setupInterface(pid, peer, n.ipv4, n.ipv6)
+	if err != nil {
+		n.teardown()
+		return nil, err
+	}
+	logrus.Infof("Connecting container to %s with address %s", networkBridge, n.ipv4)
+
+	return n, nil
+}
+
+// setupInterface configures the loopback and the given interface inside the
+// network namespace of the process with the given PID.
+func setupInterface(pid uintptr, name string, ipv4 *net.IPNet, ipv6 *net.IPNet) error {
+	ns, err := netns.GetFromPid(int(pid))
+	if err != nil {
+		return err
+	}
+	defer ns.Close()
+	handle, err := netlink.NewHandleAt(ns)
+	if err != nil {
+		return err
+	}
+	defer handle.Delete()
+
+	err = setupLoopback(pid)
+	if err != nil {
+		return err
+	}
+
+	link, err := handle.LinkByName(name)
+	if err != nil {
+		return err
+	}
+	err = handle.LinkSetName(link, networkInterface)
+	if err != nil {
+		return err
+	}
+	err = handle.AddrAdd(link, &netlink.Addr{IPNet: ipv4})
+	if err != nil {
+		return err
+	}
+	err = handle.AddrAdd(link, &netlink.Addr{IPNet: ipv6, Flags: unix.IFA_F_NODAD})
+	if err != nil {
+		logrus.Warnf("Fail to assign IPv6 address: %s", err)
+		ipv6 = nil
+	}
+	err = handle.LinkSetUp(link)
+	if err != nil {
+		return err
+	}
+
+	gateway, _, _ := net.ParseCIDR(networkSubnet)
+	err = handle.RouteAdd(&netlink.Route{LinkIndex: link.Attrs().Index, Gw: nextIP(gateway, 1)})
+	if err != nil {
+		return err
+	}
+	if ipv6 != nil {
+		gateway6, _, _ := net.ParseCIDR(networkSubnet6)
+		err = handle.RouteAdd(&netlink.Route{LinkIndex: link.Attrs().Index, Gw: nextIP(gateway6, 1)})
+		if err != nil {
+			logrus.Warnf("Fail to add IPv6 default route: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// setupLoopback brings up the loopback interface of the network namespace
+// of the process with the given PID.
+func setupLoopback(pid uintptr) error {
+	ns, err := netns.GetFromPid(int(pid))
+	if err != nil {
+		return err
+	}
+	defer ns.Close()
+	handle, err := netlink.NewHandleAt(ns)
+	if err != nil {
+		return err
+	}
+	defer handle.Delete()
+
+	lo, err := handle.LinkByName("lo")
+	if err != nil {
+		return err
+	}
+	return handle.LinkSetUp(lo)
+}
+
+// teardown removes the veth pair of the container and releases its address,
+// removing the bridge along with the last container using it.
+func (n *network) teardown() {
+	link, err := netlink.LinkByName(n.hostVeth)
+	if err == nil {
+		err = netlink.LinkDel(link)
+		if err != nil {
+			logrus.Warnf("Fail to delete %s: %s", n.hostVeth, err)
+		}
+	}
+
+	err = updateIPAM(func(store *ipamStore) error {
+		for ip, id := range store.Allocations {
+			if id == n.id {
+				delete(store.Allocations, ip)
+			}
+		}
+		if len(store.Allocations) == 0 {
+			cleanupBridge(store)
+		}
+		return nil
+	})
+	if err != nil {
+		logrus.Warnf("Fail to release address %s: %s", n.ipv4, err)
+	}
+	logrus.Debugf("Disconnecting container from %s", networkBridge)
+}
+
+// setupBridge creates the host bridge if it does not exist yet, along with
+// forwarding and NAT masquerade for the traffic leaving it. The forwarding
+// sysctls are saved in the store before they are first enabled.
+func setupBridge(store *ipamStore) error {
+	link, err := netlink.LinkByName(networkBridge)
+	if err != nil {
+		var notFound netlink.LinkNotFoundError
+		if !errors.As(err, &notFound) {
+			return err
+		}
+		err = netlink.LinkAdd(&netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: networkBridge}})
+		if err != nil {
+			return err
+		}
+		link, err = netlink.LinkByName(networkBridge)
+		if err != nil {
+			return err
+		}
+		logrus.Debugf("Creating bridge %s", networkBridge)
+	}
+
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return err
+	}
+	for _, subnet := range []string{networkSubnet, networkSubnet6} {
+		ip, ipnet, _ := net.ParseCIDR(subnet)
+		gateway := &net.IPNet{IP: nextIP(ip, 1), Mask: ipnet.Mask}
+		assigned := false
+		for _, addr := range addrs {
+			if addr.IPNet.String() == gateway.String() {
+				assigned = true
+			}
+		}
+		if assigned {
+			continue
+		}
+		err = netlink.AddrAdd(link, &netlink.Addr{IPNet: gateway, Flags: unix.IFA_F_NODAD})
+		if err != nil && ip.To4() != nil {
+			return err
+		}
+		if err != nil {
+			logrus.Warnf("Fail to assign IPv6 address to %s: %s", networkBridge, err)
+		}
+	}
+	err = netlink.LinkSetUp(link)
+	if err != nil {
+		return err
+	}
+
+	if store.Sysctls == nil {
+		store.Sysctls = map[string]string{}
+	}
+	for _, sysctl := range forwardSysctls() {
+		if _, ok := store.Sysctls[sysctl]; !ok {
+			value, err := os.ReadFile("/proc/sys/" + sysctl)
+			if err == nil {
+				store.Sysctls[sysctl] = strings.TrimSpace(string(value))
+			}
+		}
+		err = os.WriteFile("/proc/sys/"+sysctl, []byte("1"), 0)
+		if err != nil {
+			logrus.Warnf("Fail to enable %s: %s", strings.ReplaceAll(sysctl, "/", "."), err)
+		}
+	}
+	missing := map[string]bool{}
+	for _, rule := range natRules() {
+		if missing[rule[0]] {
+			continue
+		}
+		_, err = exec.LookPath(rule[0])
+		if err != nil {
+			logrus.Warnf("Fail to set up NAT, containers cannot reach outside the host: %s", err)
+			missing[rule[0]] = true
+			continue
+		}
+		err = iptables(rule, "-C")
+		if err != nil {
+			err = iptables(rule, "-A")
+		}
+		if err != nil {
+			logrus.Warnf("Fail to add %s rule: %s", rule[0], err)
+		}
+	}
+
+	return nil
+}
+
+// cleanupBridge removes the host bridge and its NAT rules, and restores the
+// forwarding sysctls saved in the store.
+func cleanupBridge(store *ipamStore) {
+	for _, rule := range natRules() {
+		for iptables(rule, "-C") == nil {
+			err := iptables(rule, "-D")
+			if err != nil {
+				logrus.Warnf("Fail to delete %s rule: %s", rule[0], err)
+				break
+			}
+		}
+	}
+
+	for sysctl, value := range store.Sysctls {
+		err := os.WriteFile("/proc/sys/"+sysctl, []byte(value), 0)
+		if err != nil {
+			logrus.Warnf("Fail to restore %s: %s", strings.ReplaceAll(sysctl, "/", "."), err)
+		}
+		delete(store.Sysctls, sysctl)
+	}
+
+	link, err := netlink.LinkByName(networkBridge)
+	if err != nil {
+		return
+	}
+	err = netlink.LinkDel(link)
+	if err != nil {
+		logrus.Warnf("Fail to delete bridge %s: %s", networkBridge, err)
+	}
+	logrus.Debugf("Deleting bridge %s", networkBridge)
+}
+
+// forwardSysctls returns the sysctls enabling the forwarding of the traffic
+// of the bridge.
+func forwardSysctls() []string {
+	return []string{"net/ipv4/ip_forward", "net/ipv6/conf/all/forwarding"}
+}
+
+// natRules returns the rules forwarding and masquerading the traffic of the
+// bridge, each as a command, a table, a chain and the rule itself.
+func natRules() [][]string {
+	return [][]string{
+		{"iptables", "nat", "POSTROUTING", "-s", networkSubnet, "!", "-o", networkBridge, "-j", "MASQUERADE"},
+		{"iptables", "filter", "FORWARD", "-i", networkBridge, "-j", "ACCEPT"},
+		{"iptables", "filter", "FORWARD", "-o", networkBridge, "-j", "ACCEPT"},
+		{"ip6tables", "nat", "POSTROUTING", "-s", networkSubnet6, "!", "-o", networkBridge, "-j", "MASQUERADE"},
+		{"ip6tables", "filter", "FORWARD", "-i", networkBridge, "-j", "ACCEPT"},
+		{"ip6tables", "filter", "FORWARD", "-o", networkBridge, "-j", "ACCEPT"},
+	}
+}
+
+// iptables applies the given operation to a rule returned by natRules.
+func iptables(rule []string, op string) error {
+	args := append([]string{"-w", "-t", rule[1], op, rule[2]}, rule[3:]...)
+	out, err := exec.Command(rule[0], args...).CombinedOutput()
+	if err != nil && len(out) > 0 {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return err
+}
+
+// allocateIP allocates the lowest free address of the bridge subnet to the
+// container, along with the IPv6 address sharing its host part. Addresses
+// of containers whose state is gone, which did not release them when they
+// died, are freed first.
+func allocateIP(store *ipamStore, id string) (*net.IPNet, *net.IPNet, error) {
+	for ip, owner := range store.Allocations {
+		_, err := os.Stat(statePath(owner, ""))
+		if errors.Is(err, os.ErrNotExist) {
+			delete(store.Allocations, ip)
+			logrus.Debugf("Releasing address %s of removed container %s", ip, owner)
+		}
+	}
+
+	base, subnet, _ := net.ParseCIDR(networkSubnet)
+	base6, subnet6, _ := net.ParseCIDR(networkSubnet6)
+	ones, bits := subnet.Mask.Size()
+	size := 1 << (bits - ones)
+
+	// The first address is the network, the second is the gateway and the
+	// last is broadcast.
+	for i := 2; i < size-1; i++ {
+		ip := nextIP(base, i)
+		if _, ok := store.Allocations[ip.String()]; ok {
+			continue
+		}
+		store.Allocations[ip.String()] = id
+
+		ip6 := make(net.IP, net.IPv6len)
+		copy(ip6, base6.To16())
+		copy(ip6[net.IPv6len-net.IPv4len:], ip.To4())
+		return &net.IPNet{IP: ip, Mask: subnet.Mask}, &net.IPNet{IP: ip6, Mask: subnet6.Mask}, nil
+	}
+
+	return nil, nil, fmt.Errorf("%w: %s", ErrNetworkExhausted, networkSubnet)
+}
+
+// nextIP returns the IPv4 or IPv6 address n addresses after ip.
+func nextIP(ip net.IP, n int) net.IP {
+	if v4 := ip.To4(); v4 != nil {
+		next := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(next, binary.BigEndian.Uint32(v4)+uint32(n))
+		return next
+	}
+
+	next := make(net.IP, net.IPv6len)
+	copy(next, ip.To16())
+	low := binary.BigEndian.Uint64(next[8:]) + uint64(n)
+	binary.BigEndian.PutUint64(next[8:], low)
+	return next
+}
+
+// updateIPAM applies fn to the IPAM store while holding a lock on it.
+func updateIPAM(fn func(store *ipamStore) error) error {
+	file, err := os.OpenFile(networkIPAMFile, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	store := &ipamStore{}
+	if len(data) > 0 {
+		err = json.Unmarshal(data, store)
+		if err != nil {
+			return err
+		}
+	}
+	if store.Allocations == nil {
+		store.Allocations = map[string]string{}
+	}
+
+	err = fn(store)
+	if err != nil {
+		return err
+	}
+
+	data, err = json.Marshal(store)
+	if err != nil {
+		return err
+	}
+	err = file.Truncate(0)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteAt(data, 0)
+	return err
+}
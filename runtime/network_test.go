@@ -0,0 +1,81 @@
+package runtime
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+)
+
+func TestAllocateIP(t *testing.T) {
+	// Only the addresses of containers with a state directory are kept.
+	for _, id := range []string{"test-ipam-a", "test-ipam-b", "test-ipam-c", "test-ipam-other"} {
+		dir := statePath(id, "")
+		err := os.MkdirAll(dir, 0711)
+		if err != nil {
+			t.Skipf("creating state directory: %v", err)
+		}
+		t.Cleanup(func() { os.RemoveAll(dir) })
+	}
+
+	store := &ipamStore{Allocations: map[string]string{"10.99.0.3": "test-ipam-other", "10.99.0.5": "test-ipam-removed"}}
+	tests := []struct {
+		id, ipv4, ipv6 string
+	}{
+		{"test-ipam-a", "10.99.0.2/16", "fd99:6770:6869::a63:2/64"},
+		{"test-ipam-b", "10.99.0.4/16", "fd99:6770:6869::a63:4/64"},
+	}
+
+	for _, tt := range tests {
+		ipv4, ipv6, err := allocateIP(store, tt.id)
+		if err != nil {
+			t.Fatalf("allocateIP(%s) error = %v", tt.id, err)
+		}
+		if ipv4.String() != tt.ipv4 || ipv6.String() != tt.ipv6 {
+			t.Errorf("allocateIP(%s) = %s, %s, want %s, %s", tt.id, ipv4, ipv6, tt.ipv4, tt.ipv6)
+		}
+		if store.Allocations[ipv4.IP.String()] != tt.id {
+			t.Errorf("allocateIP(%s) did not record %s", tt.id, ipv4.IP)
+		}
+	}
+
+	if _, ok := store.Allocations["10.99.0.5"]; ok {
+		t.Errorf("allocateIP() kept 10.99.0.5 of a removed container")
+	}
+
+	delete(store.Allocations, "10.99.0.2")
+	ipv4, _, err := allocateIP(store, "test-ipam-c")
+	if err != nil || ipv4.IP.String() != "10.99.0.2" {
+		t.Errorf("allocateIP(test-ipam-c) = %s, %v, want the released 10.99.0.2", ipv4, err)
+	}
+
+	full := &ipamStore{Allocations: map[string]string{}}
+	base, _, _ := net.ParseCIDR(networkSubnet)
+	for i := 2; i < 1<<16-1; i++ {
+		full.Allocations[nextIP(base, i).String()] = "test-ipam-other"
+	}
+	_, _, err = allocateIP(full, "test-ipam-c")
+	if !errors.Is(err, ErrNetworkExhausted) {
+		t.Errorf("allocateIP() on a full subnet error = %v, want %v", err, ErrNetworkExhausted)
+	}
+}
+
+func TestNextIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		n    int
+		want string
+	}{
+		{"10.99.0.0", 2, "10.99.0.2"},
+		{"10.99.0.255", 1, "10.99.1.0"},
+		{"10.99.255.255", 1, "10.100.0.0"},
+		{"fd99::", 2, "fd99::2"},
+	}
+
+	for _, tt := range tests {
+		got := nextIP(net.ParseIP(tt.ip), tt.n)
+		if got.String() != tt.want {
+			t.Errorf("nextIP(%s, %d) = %s, want %s", tt.ip, tt.n, got, tt.want)
+		}
+	}
+}
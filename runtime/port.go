@@ -0,0 +1,303 @@
+package runtime
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	goruntime "runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netns"
+)
+
+const (
+	annotationPorts = "org.gophinator.ports"
+	udpIdleTimeout  = 90 * time.Second
+	udpBufferSize   = 65507
+)
+
+// PortMapping publishes a port of the container on the host.
+type PortMapping struct {
+	HostIP        string `json:"hostIP,omitempty"`
+	HostPort      uint16 `json:"hostPort"`
+	ContainerPort uint16 `json:"containerPort"`
+	Protocol      string `json:"protocol"`
+}
+
+// ParsePortMapping parses a mapping in the form
+// [hostIP:]hostPort:containerPort[/tcp|udp].
+func ParsePortMapping(s string) (PortMapping, error) {
+	mapping := PortMapping{Protocol: "tcp"}
+	spec, proto, ok := strings.Cut(s, "/")
+	if ok {
+		mapping.Protocol = proto
+	}
+	sep := strings.LastIndex(spec, ":")
+	if sep < 0 {
+		return mapping, fmt.Errorf("%w: %q", ErrInvalidPort, s)
+	}
+	host, container := spec[:sep], spec[sep+1:]
+	if strings.Contains(host, ":") {
+		var err error
+		mapping.HostIP, host, err = net.SplitHostPort(host)
+		if err != nil {
+			return mapping, fmt.Errorf("%w: %q", ErrInvalidPort, s)
+		}
+	}
+
+	for _, port := range []struct {
+		value string
+		dst   *uint16
+	}{{host, &mapping.HostPort}, {container, &mapping.ContainerPort}} {
+		n, err := strconv.ParseUint(port.value, 10, 16)
+		if err != nil {
+			return mapping, fmt.Errorf("%w: %q", ErrInvalidPort, s)
+		}
+		*port.dst = uint16(n)
+	}
+
+	return mapping, mapping.validate()
+}
+
+// validate checks that the mapping can be published.
+func (m PortMapping) validate() error {
+	switch {
+	case m.Protocol != "tcp" && m.Protocol != "udp":
+		return fmt.Errorf("%w: unknown protocol %q", ErrInvalidPort, m.Protocol)
+	case m.HostPort == 0 || m.ContainerPort == 0:
+		return fmt.Errorf("%w: port must be between 1 and 65535", ErrInvalidPort)
+	case m.HostIP != "" && net.ParseIP(m.HostIP) == nil:
+		return fmt.Errorf("%w: invalid host IP %q", ErrInvalidPort, m.HostIP)
+	}
+	return nil
+}
+
+// String returns the mapping in the form hostIP:hostPort->containerPort/proto.
+func (m PortMapping) String() string {
+	ip := m.HostIP
+	if ip == "" {
+		ip = "0.0.0.0"
+	}
+	return fmt.Sprintf("%s->%d/%s", net.JoinHostPort(ip, strconv.Itoa(int(m.HostPort))), m.ContainerPort, m.Protocol)
+}
+
+// portMappings returns the ports published by a container.
+func portMappings(spec *specs.Spec) []PortMapping {
+	mappings := []PortMapping{}
+	data, ok := spec.Annotations[annotationPorts]
+	if !ok {
+		return mappings
+	}
+	err := json.Unmarshal([]byte(data), &mappings)
+	if err != nil {
+		logrus.Warnf("Fail to read published ports: %s", err)
+	}
+	return mappings
+}
+
+// portProxy relays the connections to published ports to the container.
+type portProxy struct {
+	pid    uintptr
+	target net.IP
+
+	mu        sync.Mutex
+	listeners []io.Closer
+	conns     map[io.Closer]struct{}
+}
+
+// startPortProxy listens on the published ports and relays every connection
+// to the given address of the container. A loopback target is reached from
+// inside the network namespace of the process with the given PID.
+func startPortProxy(mappings []PortMapping, pid uintptr, target net.IP) (*portProxy, error) {
+	p := &portProxy{pid: pid, target: target, conns: map[io.Closer]struct{}{}}
+	for _, m := range mappings {
+		addr := net.JoinHostPort(m.HostIP, strconv.Itoa(int(m.HostPort)))
+		port := int(m.ContainerPort)
+		if m.Protocol == "udp" {
+			udpAddr, err := net.ResolveUDPAddr("udp", addr)
+			if err == nil {
+				var listener *net.UDPConn
+				listener, err = net.ListenUDP("udp", udpAddr)
+				if err == nil {
+					p.listeners = append(p.listeners, listener)
+					go p.proxyUDP(listener, port)
+				}
+			}
+			if err != nil {
+				p.close()
+				return nil, err
+			}
+		} else {
+			listener, err := net.Listen("tcp", addr)
+			if err != nil {
+				p.close()
+				return nil, err
+			}
+			p.listeners = append(p.listeners, listener)
+			go p.proxyTCP(listener, port)
+		}
+		logrus.Infof("Publishing port %s", m)
+	}
+
+	return p, nil
+}
+
+// proxyTCP relays the connections accepted by the listener to the port.
+func (p *portProxy) proxyTCP(listener net.Listener, port int) {
+	for {
+		client, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer client.Close()
+			conn, err := p.dial("tcp", port)
+			if err != nil {
+				logrus.Debugf("Dialing container port %d failed: %s", port, err)
+				return
+			}
+			defer conn.Close()
+			if !p.track(conn) {
+				return
+			}
+			defer p.untrack(conn)
+
+			done := make(chan struct{})
+			go func() {
+				io.Copy(conn, client)
+				conn.(*net.TCPConn).CloseWrite()
+				close(done)
+			}()
+			io.Copy(client, conn)
+			client.(*net.TCPConn).CloseWrite()
+			<-done
+		}()
+	}
+}
+
+// proxyUDP relays the datagrams received by the listener to the port, with
+// one connection to the container per client.
+func (p *portProxy) proxyUDP(listener *net.UDPConn, port int) {
+	var mu sync.Mutex
+	clients := map[string]net.Conn{}
+	buf := make([]byte, udpBufferSize)
+	for {
+		n, client, err := listener.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		mu.Lock()
+		conn, ok := clients[client.String()]
+		if !ok {
+			conn, err = p.dial("udp", port)
+			if err != nil {
+				mu.Unlock()
+				logrus.Debugf("Dialing container port %d failed: %s", port, err)
+				continue
+			}
+			clients[client.String()] = conn
+			p.track(conn)
+			go func(conn net.Conn, client *net.UDPAddr) {
+				reply := make([]byte, udpBufferSize)
+				for {
+					conn.SetReadDeadline(time.Now().Add(udpIdleTimeout))
+					n, err := conn.Read(reply)
+					if err != nil {
+						break
+					}
+					listener.WriteToUDP(reply[:n], client)
+				}
+				mu.Lock()
+				delete(clients, client.String())
+				mu.Unlock()
+				p.untrack(conn)
+				conn.Close()
+			}(conn, client)
+		}
+		mu.Unlock()
+		conn.Write(buf[:n])
+	}
+}
+
+// dial connects to the port of the container.
+func (p *portProxy) dial(proto string, port int) (net.Conn, error) {
+	addr := net.JoinHostPort(p.target.String(), strconv.Itoa(port))
+	if !p.target.IsLoopback() {
+		return net.Dial(proto, addr)
+	}
+
+	// Sockets belong to the network namespace of the thread creating them.
+	goruntime.LockOSThread()
+	origin, err := netns.Get()
+	if err != nil {
+		goruntime.UnlockOSThread()
+		return nil, err
+	}
+	defer origin.Close()
+	ns, err := netns.GetFromPid(int(p.pid))
+	if err != nil {
+		goruntime.UnlockOSThread()
+		return nil, err
+	}
+	defer ns.Close()
+	err = netns.Set(ns)
+	if err != nil {
+		goruntime.UnlockOSThread()
+		return nil, err
+	}
+	conn, dialErr := net.Dial(proto, addr)
+	err = netns.Set(origin)
+	if err != nil {
+		// The thread is left locked so that it exits with the goroutine
+		// instead of running others in the wrong namespace.
+		if conn != nil {
+			conn.Close()
+		}
+		return nil, errors.Join(dialErr, err)
+	}
+	goruntime.UnlockOSThread()
+
+	return conn, dialErr
+}
+
+// track records a connection to close along with the proxy, reporting false
+// when the proxy is already closed.
+func (p *portProxy) track(conn io.Closer) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conns == nil {
+		conn.Close()
+		return false
+	}
+	p.conns[conn] = struct{}{}
+	return true
+}
+
+// untrack forgets a closed connection.
+func (p *portProxy) untrack(conn io.Closer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.conns, conn)
+}
+
+// close stops listening on the published ports and closes every relayed
+// connection.
+func (p *portProxy) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, listener := range p.listeners {
+		listener.Close()
+	}
+	for conn := range p.conns {
+		conn.Close()
+	}
+	p.listeners, p.conns = nil, nil
+}
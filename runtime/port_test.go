@@ -0,0 +1,60 @@
+package runtime
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParsePortMapping(t *testing.T) {
+	tests := []struct {
+		in   string
+		want PortMapping
+		err  error
+	}{
+		{"8080:80", PortMapping{HostPort: 8080, ContainerPort: 80, Protocol: "tcp"}, nil},
+		{"53:53/udp", PortMapping{HostPort: 53, ContainerPort: 53, Protocol: "udp"}, nil},
+		{"127.0.0.1:8080:80/tcp", PortMapping{HostIP: "127.0.0.1", HostPort: 8080, ContainerPort: 80, Protocol: "tcp"}, nil},
+		{"[::1]:8080:80", PortMapping{HostIP: "::1", HostPort: 8080, ContainerPort: 80, Protocol: "tcp"}, nil},
+		{"80", PortMapping{}, ErrInvalidPort},
+		{"0:80", PortMapping{}, ErrInvalidPort},
+		{"8080:0", PortMapping{}, ErrInvalidPort},
+		{"70000:80", PortMapping{}, ErrInvalidPort},
+		{"http:80", PortMapping{}, ErrInvalidPort},
+		{"8080:80/sctp", PortMapping{}, ErrInvalidPort},
+		{"1.2.3:8080:80", PortMapping{}, ErrInvalidPort},
+		{"::1:8080:80", PortMapping{}, ErrInvalidPort},
+	}
+
+	for _, tt := range tests {
+		got, err := ParsePortMapping(tt.in)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("ParsePortMapping(%q) error = %v, want %v", tt.in, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParsePortMapping(%q) error = %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParsePortMapping(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPortMappingString(t *testing.T) {
+	tests := []struct {
+		in   PortMapping
+		want string
+	}{
+		{PortMapping{HostPort: 8080, ContainerPort: 80, Protocol: "tcp"}, "0.0.0.0:8080->80/tcp"},
+		{PortMapping{HostIP: "::1", HostPort: 53, ContainerPort: 53, Protocol: "udp"}, "[::1]:53->53/udp"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.in.String(); got != tt.want {
+			t.Errorf("%+v.String() = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -1,11 +1,15 @@
 package runtime
 
 import (
+	"fmt"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"strings"
 	"syscall"
 	"unsafe"
 
+	"github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/sirupsen/logrus"
 )
 
@@ -30,21 +34,24 @@ func cleanupSocketPair(sockets [2]int) {
 
 // childDaemon is the main loop for the container.
 func childDaemon(r *Runtime, fd int) int {
-	logrus.Infof("Starting container with command: %s %s", r.command, strings.Join(r.args, " "))
-
-	err := syscall.Sethostname([]byte(r.hostname))
-	if err != nil {
-		logrus.Errorf("Fail to set hostname: %s", err)
-		return -1
+	process := r.spec.Process
+	logrus.Infof("Starting container with command: %s", r)
+
+	if hasNamespace(r.spec.Linux.Namespaces, specs.UTSNamespace) {
+		err := syscall.Sethostname([]byte(r.hostname))
+		if err != nil {
+			logrus.Errorf("Fail to set hostname: %s", err)
+			return -1
+		}
 	}
 
-	err = mountFilesys(r, fd)
+	err := mountFilesys(r, fd)
 	if err != nil {
 		logrus.Errorf("Fail to mount filesystem: %s", err)
 		return -1
 	}
 
-	err = setupNamespace(fd)
+	err = setupNamespace(fd, hasNamespace(r.spec.Linux.Namespaces, specs.UserNamespace))
 	if err != nil {
 		logrus.Errorf("Fail to setup namespaces: %s", err)
 		return -1
@@ -54,22 +61,65 @@ func childDaemon(r *Runtime, fd int) int {
 		logrus.Errorf("Fail to close socket: %d", fd)
 	}
 
-	err = switchNamespace(r.uid)
+	if process.Terminal {
+		err = setupConsole(r.pts)
+		if err != nil {
+			logrus.Errorf("Fail to setup console: %s", err)
+			return -1
+		}
+	}
+	if r.stdio != nil {
+		err = r.stdio.setup()
+		if err != nil {
+			logrus.Errorf("Fail to setup stdio: %s", err)
+			return -1
+		}
+	}
+
+	err = setupRlimits(process.Rlimits)
+	if err != nil {
+		logrus.Errorf("Fail to setup rlimits: %s", err)
+		return -1
+	}
+
+	err = os.MkdirAll(process.Cwd, 0755)
+	if err == nil {
+		err = os.Chdir(process.Cwd)
+	}
+	if err != nil {
+		logrus.Errorf("Fail to change directory: %s", err)
+		return -1
+	}
+
+	err = switchNamespace(int(process.User.UID), int(process.User.GID))
 	if err != nil {
 		logrus.Errorf("Fail to switch namespaces: %s", err)
 		return -1
 	}
-	logrus.Infof("Setup namespace with UID %d", r.uid)
+	logrus.Infof("Setup namespace with UID %d", process.User.UID)
 
-	err = setupSyscall()
+	if r.spec.Annotations[annotationSeccompLearn] != "" {
+		err = setupSyscallLearning()
+	} else {
+		err = setupSyscall(r.spec.Linux.Seccomp)
+	}
 	if err != nil {
 		logrus.Errorf("Fail to setup syscall: %s", err)
 		return -1
 	}
 	logrus.Infof("Setup syscall successfully")
 
-	argv := append([]string{r.command}, r.args...)
-	err = syscall.Exec(r.command, argv, os.Environ())
+	if r.initExe != nil {
+		err = execInit(r.initExe, process.Args, process.Env)
+		logrus.Errorf("Fail to exec init: %s", err)
+		return -1
+	}
+	command, err := lookPath(process.Args[0], process.Env)
+	if err != nil {
+		logrus.Errorf("Fail to find command: %s", err)
+		return -1
+	}
+	err = syscall.Exec(command, process.Args, process.Env)
 	if err != nil {
 		logrus.Errorf("Fail to exec command: %s", err)
 		return -1
@@ -78,18 +128,11 @@ func childDaemon(r *Runtime, fd int) int {
 	return 0
 }
 
-// spawnChild creates a new process in a new namespace.
-func spawnChild(r *Runtime, fd int) (uintptr, error) {
+// spawnChild creates a new process in the namespaces given by flags.
+func spawnChild(r *Runtime, flags uintptr, fd int) (uintptr, error) {
 	r1, _, err := syscall.Syscall(
 		syscall.SYS_CLONE,
-		uintptr(
-			syscall.SIGCHLD|
-				syscall.CLONE_NEWNS|
-				syscall.CLONE_NEWCGROUP|
-				syscall.CLONE_NEWPID|
-				syscall.CLONE_NEWIPC|
-				syscall.CLONE_NEWNET|
-				syscall.CLONE_NEWUTS),
+		uintptr(syscall.SIGCHLD)|flags,
 		0, 0)
 	if err != 0 {
 		return 0, err
@@ -111,3 +154,27 @@ func waitChild(pid uintptr) (int, error) {
 
 	return stat.ExitStatus(), nil
 }
+
+// lookPath searches for an executable named file in the PATH of the given
+// environment, as seen from the current root.
+func lookPath(file string, env []string) (string, error) {
+	if strings.Contains(file, "/") {
+		return file, nil
+	}
+
+	path := processDefaultPath
+	for _, kv := range env {
+		if strings.HasPrefix(kv, "PATH=") {
+			path = strings.TrimPrefix(kv, "PATH=")
+		}
+	}
+	for _, dir := range filepath.SplitList(path) {
+		candidate := filepath.Join(dir, file)
+		info, err := os.Stat(candidate)
+		if err == nil && info.Mode().IsRegular() && info.Mode().Perm()&0111 != 0 {
+			return candidate, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: %s", exec.ErrNotFound, file)
+}
@@ -1,51 +1,87 @@
 package runtime
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"syscall"
 
-	"github.com/containerd/cgroups/v3/cgroup1"
 	"github.com/google/uuid"
 	"github.com/opencontainers/runtime-spec/specs-go"
 	seccomp "github.com/seccomp/libseccomp-golang"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
 )
 
 const (
 	filesysPrefix       = "/tmp/gophinator."
+	filesysRoot         = "root"
+	filesysUpper        = "upper"
+	filesysWork         = "work"
 	filesysOldRoot      = "/oldroot."
 	filesysMountFail    = 0x0
 	filesysMountSuccess = 0x1
+	annotationOverlay   = "org.gophinator.overlay"
+	annotationRemove    = "org.gophinator.rm"
 )
 
 // mountFilesys mounts the filesystem.
 func mountFilesys(rt *Runtime, fd int) error {
-	root := filesysPrefix + rt.uuid
-	err := os.MkdirAll(root, 0755)
+	err := setupPropagation(rt.spec)
+	if err != nil {
+		return err
+	}
+
+	root := filepath.Join(filesysPrefix+rt.uuid, filesysRoot)
+	err = os.MkdirAll(root, 0755)
 	if err != nil {
 		return err
 	}
 	logrus.Debugf("Creating root directory %s", root)
 
-	err = syscall.Mount(rt.root, root, "", uintptr(syscall.MS_BIND|syscall.MS_PRIVATE), "")
+	// The mounts of the container must not reach the host through the mount
+	// holding its root, which pivot_root also refuses to be shared.
+	parent, err := findMount(root)
+	if err != nil {
+		return err
+	}
+	err = syscall.Mount("", parent.Destination, "", syscall.MS_SLAVE, "")
 	if err != nil {
 		return err
 	}
-	logrus.Debugf("Mounting root %s to %s", rt.root, root)
 
-	for _, volume := range rt.volumes {
-		source := volume.Source
-		target := root + "/" + volume.Target
-		err = os.MkdirAll(target, 0755)
+	if rt.spec.Annotations[annotationOverlay] == "true" {
+		lower := rt.spec.Root.Path
+		if layers := rt.spec.Annotations[annotationLayers]; layers != "" {
+			lower = layers
+		}
+		err = mountOverlay(lower, filesysPrefix+rt.uuid)
+	} else {
+		err = syscall.Mount(rt.spec.Root.Path, root, "", uintptr(syscall.MS_BIND|syscall.MS_PRIVATE), "")
+	}
+	if err != nil {
+		return err
+	}
+	logrus.Debugf("Mounting root %s to %s", rt.spec.Root.Path, root)
+
+	// The mounts are made in the order of their destinations, the kernel
+	// filesystems included, which already show the namespaces of the
+	// container, so that none hides a mount made below it.
+	for _, m := range rt.spec.Mounts {
+		err = mountSpec(root, m)
 		if err != nil {
 			return err
 		}
-		err = syscall.Mount(source, target, "", uintptr(syscall.MS_BIND|syscall.MS_PRIVATE), "")
+		logrus.Debugf("Mounting %s %s to %s", m.Type, m.Source, mountTarget(root, m))
+	}
+	if needsDevices(rt.spec) {
+		err = setupDevices(rt, root)
 		if err != nil {
 			return err
 		}
-		logrus.Debugf("Mounting volume %s to %s", source, target)
 	}
 
 	uid := uuid.NewString()
@@ -56,6 +92,10 @@ func mountFilesys(rt *Runtime, fd int) error {
 	}
 	logrus.Debugf("Creating old root directory %s", oldRoot)
 
+	err = syscall.Mount("", "/", "", syscall.MS_SLAVE, "")
+	if err != nil {
+		return err
+	}
 	err = syscall.PivotRoot(root, oldRoot)
 	if err != nil {
 		return err
@@ -66,6 +106,11 @@ func mountFilesys(rt *Runtime, fd int) error {
 	if err != nil {
 		return err
 	}
+	// Unmounting the old root must not unmount the host.
+	err = syscall.Mount("", filesysOldRoot+uid, "", syscall.MS_SLAVE|syscall.MS_REC, "")
+	if err != nil {
+		return err
+	}
 	err = syscall.Unmount(filesysOldRoot+uid, syscall.MNT_DETACH)
 	if err != nil {
 		return err
@@ -76,45 +121,391 @@ func mountFilesys(rt *Runtime, fd int) error {
 	}
 	logrus.Debugf("Unmounting old root")
 
+	if rt.spec.Root.Readonly {
+		err = syscall.Mount("", "/", "", uintptr(syscall.MS_REMOUNT|syscall.MS_BIND|syscall.MS_RDONLY), "")
+		if err != nil {
+			return err
+		}
+		logrus.Debugf("Remounting root read-only")
+	}
+
 	err = syscall.Sendto(fd, []byte{filesysMountSuccess}, 0, nil)
 	if err != nil {
 		return err
 	}
-	logrus.Infof("Mount %s => %s => /", rt.root, root)
-	for _, volume := range rt.volumes {
-		logrus.Infof("Mount %s => %s => %s", volume.Source, root+"/"+volume.Target, volume.Target)
+	logrus.Infof("Mount %s => %s => /", rt.spec.Root.Path, root)
+	for _, m := range rt.spec.Mounts {
+		logrus.Infof("Mount %s => %s => %s", m.Source, mountTarget(root, m), m.Destination)
 	}
 
 	return nil
 }
 
-// cleanupFilesys cleans up the filesystem.
-func cleanupFilesys(rootUUID string) {
-	os.RemoveAll(filesysPrefix + rootUUID)
+// rootPropagation returns the propagation the mounts of the container get
+// before its own are made. Unless the spec gives one, they are private, or
+// slave when a bind mount asks for a shared or slave propagation so that its
+// source keeps receiving the mounts of the host.
+func rootPropagation(spec *specs.Spec) string {
+	if spec.Linux.RootfsPropagation != "" {
+		return spec.Linux.RootfsPropagation
+	}
+
+	for i := range spec.Mounts {
+		_, flags := splitPropagation(spec.Mounts[i].Options)
+		if isBindMount(&spec.Mounts[i]) && flags&(syscall.MS_SHARED|syscall.MS_SLAVE) != 0 {
+			return "rslave"
+		}
+	}
+	return "rprivate"
 }
 
-const (
-	namespaceSetupFail    = 0x0
-	namespaceSetupSuccess = 0x1
-)
+// setupPropagation applies the root propagation to the mounts of the
+// container. The mounts holding the sources of shared bind mounts are left
+// shared, so that those bind mounts stay peers of the host while no other
+// mount propagates to it.
+func setupPropagation(spec *specs.Spec) error {
+	propagation := rootPropagation(spec)
+	shared := []string{}
+	for i := range spec.Mounts {
+		m := &spec.Mounts[i]
+		_, flags := splitPropagation(m.Options)
+		if spec.Linux.RootfsPropagation != "" || !isBindMount(m) || flags&syscall.MS_SHARED == 0 {
+			continue
+		}
+		source, err := findMount(m.Source)
+		if err != nil {
+			return err
+		}
+		shared = append(shared, source.Destination)
+	}
 
-// setupNamespace sets up the namespaces.
-func setupNamespace(fd int) error {
-	err := syscall.Unshare(syscall.CLONE_NEWUSER)
+	if len(shared) == 0 {
+		flags, _ := parseMountOptions([]string{propagation})
+		err := syscall.Mount("", "/", "", flags, "")
+		if err != nil {
+			return err
+		}
+		logrus.Debugf("Setting mount propagation to %s", propagation)
+		return nil
+	}
+
+	mounts, err := readMountInfo("/proc/self/mountinfo")
 	if err != nil {
-		logrus.Debugf("Unsharing user namespace is not supported: %s", err)
-		err = syscall.Sendto(fd, []byte{namespaceSetupFail}, 0, nil)
+		return err
+	}
+	for _, m := range mounts {
+		if slices.Contains(shared, m.Destination) {
+			continue
+		}
+		err = syscall.Mount("", m.Destination, "", syscall.MS_SLAVE, "")
+		// Mount points hidden by another mount are out of reach.
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("setting propagation of %s: %w", m.Destination, err)
+		}
+	}
+	logrus.Debugf("Setting mount propagation to %s but for %s", propagation, strings.Join(shared, ", "))
+
+	return nil
+}
+
+// checkPropagation checks that the host mount holding the source of a bind
+// mount can give it the propagation of its options, which needs a shared
+// mount for a shared propagation and a shared or slave one for a slave one.
+func checkPropagation(source string, options []string) error {
+	_, flags := splitPropagation(options)
+	if flags&(syscall.MS_SHARED|syscall.MS_SLAVE) == 0 {
+		return nil
+	}
+	m, err := findMount(source)
+	if err != nil {
+		return err
+	}
+	shared := strings.Contains(m.Propagation, "shared:")
+	slave := strings.Contains(m.Propagation, "master:")
+	if flags&syscall.MS_SHARED != 0 && !shared {
+		return fmt.Errorf("%s is not a shared mount", m.Destination)
+	}
+	if !shared && !slave {
+		return fmt.Errorf("%s is neither a shared nor a slave mount", m.Destination)
+	}
+
+	return nil
+}
+
+// findMount returns the mount of the current process holding path.
+func findMount(path string) (MountInfo, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return MountInfo{}, err
+	}
+	path, err = filepath.EvalSymlinks(path)
+	if err != nil {
+		return MountInfo{}, err
+	}
+	mounts, err := readMountInfo("/proc/self/mountinfo")
+	if err != nil {
+		return MountInfo{}, err
+	}
+
+	// The last of the mounts stacked on the deepest mount point is visible.
+	found := MountInfo{Destination: "/"}
+	for _, m := range mounts {
+		rel, err := filepath.Rel(m.Destination, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, "../") && len(m.Destination) >= len(found.Destination) {
+			found = m
+		}
+	}
+	return found, nil
+}
+
+// mountOverlay mounts an overlay at the root directory of the given area,
+// with lower as its colon separated read-only lower layers and the upper
+// and work directories of the area receiving the writes.
+func mountOverlay(lower string, area string) error {
+	upper := filepath.Join(area, filesysUpper)
+	work := filepath.Join(area, filesysWork)
+	for _, dir := range []string{upper, work} {
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			return err
+		}
+	}
+
+	data := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", lower, upper, work)
+	err := syscall.Mount("overlay", filepath.Join(area, filesysRoot), "overlay", 0, data)
+	if err != nil {
+		return fmt.Errorf("mounting overlay on %s: %w", lower, err)
+	}
+	logrus.Debugf("Mounting overlay with upper directory %s", upper)
+
+	return nil
+}
+
+// mountTarget returns the host path a mount is placed at below root.
+func mountTarget(root string, m specs.Mount) string {
+	return filepath.Join(root, filepath.Clean("/"+m.Destination))
+}
+
+// mountSpec performs a mount from the spec below the given root. Bind
+// mounts are remounted to apply their flags, which the kernel ignores when
+// binding, and the propagation is changed by a call of its own.
+func mountSpec(root string, m specs.Mount) error {
+	target := mountTarget(root, m)
+	options, propagation := splitPropagation(m.Options)
+	flags, data := parseMountOptions(options)
+	fstype := m.Type
+	bind := isBindMount(&m)
+	if bind {
+		flags |= syscall.MS_BIND
+		fstype = ""
+	}
+
+	info, err := os.Stat(m.Source)
+	if bind && err == nil && !info.IsDir() {
+		err = os.MkdirAll(filepath.Dir(target), 0755)
 		if err != nil {
 			return err
 		}
+		file, err := os.OpenFile(target, os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		file.Close()
 	} else {
-		logrus.Debugf("Unsharing user namespace successfully")
-		err = syscall.Sendto(fd, []byte{namespaceSetupSuccess}, 0, nil)
+		err = os.MkdirAll(target, 0755)
 		if err != nil {
 			return err
 		}
 	}
 
+	err = syscall.Mount(m.Source, target, fstype, flags, data)
+	if err != nil {
+		return err
+	}
+	if bind && flags&^(syscall.MS_BIND|syscall.MS_REC) != 0 {
+		err = remountBind(target, flags)
+		if err != nil {
+			return fmt.Errorf("remounting %s: %w", target, err)
+		}
+	}
+	if propagation != 0 {
+		err = syscall.Mount("", target, "", propagation, "")
+		if err != nil {
+			return fmt.Errorf("setting propagation of %s: %w", target, err)
+		}
+	}
+
+	return nil
+}
+
+// splitPropagation separates the propagation from the other mount options.
+func splitPropagation(options []string) ([]string, uintptr) {
+	var propagations = map[string]uintptr{
+		"private":     syscall.MS_PRIVATE,
+		"rprivate":    syscall.MS_PRIVATE | syscall.MS_REC,
+		"shared":      syscall.MS_SHARED,
+		"rshared":     syscall.MS_SHARED | syscall.MS_REC,
+		"slave":       syscall.MS_SLAVE,
+		"rslave":      syscall.MS_SLAVE | syscall.MS_REC,
+		"unbindable":  syscall.MS_UNBINDABLE,
+		"runbindable": syscall.MS_UNBINDABLE | syscall.MS_REC,
+	}
+
+	var propagation uintptr
+	rest := []string{}
+	for _, option := range options {
+		if flag, ok := propagations[option]; ok {
+			propagation = flag
+		} else {
+			rest = append(rest, option)
+		}
+	}
+	return rest, propagation
+}
+
+// remountBind applies the flags of a bind mount, keeping the flags the
+// source is locked with. The flags reach the mounts below a recursive bind
+// mount only on kernels supporting mount_setattr.
+func remountBind(target string, flags uintptr) error {
+	var stat unix.Statfs_t
+	err := unix.Statfs(target, &stat)
+	if err != nil {
+		return err
+	}
+	locked := uintptr(stat.Flags) & (unix.MS_NOSUID | unix.MS_NODEV | unix.MS_NOEXEC)
+	err = syscall.Mount("", target, "", syscall.MS_REMOUNT|flags&^syscall.MS_REC|locked, "")
+	if err != nil || flags&syscall.MS_REC == 0 {
+		return err
+	}
+
+	var attrs = map[uintptr]uint64{
+		syscall.MS_RDONLY: unix.MOUNT_ATTR_RDONLY,
+		syscall.MS_NOSUID: unix.MOUNT_ATTR_NOSUID,
+		syscall.MS_NODEV:  unix.MOUNT_ATTR_NODEV,
+		syscall.MS_NOEXEC: unix.MOUNT_ATTR_NOEXEC,
+	}
+	attr := &unix.MountAttr{}
+	for flag, a := range attrs {
+		if flags&flag != 0 {
+			attr.Attr_set |= a
+		}
+	}
+	if attr.Attr_set == 0 {
+		return nil
+	}
+	err = unix.MountSetattr(unix.AT_FDCWD, target, unix.AT_RECURSIVE, attr)
+	if errors.Is(err, syscall.ENOSYS) {
+		logrus.Warnf("Fail to apply mount flags below %s: %s", target, err)
+		return nil
+	}
+	return err
+}
+
+// isBindMount reports whether m bind mounts a host path.
+func isBindMount(m *specs.Mount) bool {
+	if m.Type == "bind" {
+		return true
+	}
+	for _, option := range m.Options {
+		if option == "bind" || option == "rbind" {
+			return true
+		}
+	}
+	return false
+}
+
+// parseMountOptions converts fstab style mount options into mount flags and
+// the remaining filesystem specific data.
+func parseMountOptions(options []string) (uintptr, string) {
+	var flags = map[string]struct {
+		clear bool
+		flag  uintptr
+	}{
+		"defaults":    {false, 0},
+		"ro":          {false, syscall.MS_RDONLY},
+		"rw":          {true, syscall.MS_RDONLY},
+		"nosuid":      {false, syscall.MS_NOSUID},
+		"suid":        {true, syscall.MS_NOSUID},
+		"nodev":       {false, syscall.MS_NODEV},
+		"dev":         {true, syscall.MS_NODEV},
+		"noexec":      {false, syscall.MS_NOEXEC},
+		"exec":        {true, syscall.MS_NOEXEC},
+		"sync":        {false, syscall.MS_SYNCHRONOUS},
+		"async":       {true, syscall.MS_SYNCHRONOUS},
+		"noatime":     {false, syscall.MS_NOATIME},
+		"atime":       {true, syscall.MS_NOATIME},
+		"nodiratime":  {false, syscall.MS_NODIRATIME},
+		"diratime":    {true, syscall.MS_NODIRATIME},
+		"relatime":    {false, syscall.MS_RELATIME},
+		"norelatime":  {true, syscall.MS_RELATIME},
+		"strictatime": {false, syscall.MS_STRICTATIME},
+		"mand":        {false, syscall.MS_MANDLOCK},
+		"nomand":      {true, syscall.MS_MANDLOCK},
+		"remount":     {false, syscall.MS_REMOUNT},
+		"bind":        {false, syscall.MS_BIND},
+		"rbind":       {false, syscall.MS_BIND | syscall.MS_REC},
+		"private":     {false, syscall.MS_PRIVATE},
+		"rprivate":    {false, syscall.MS_PRIVATE | syscall.MS_REC},
+		"shared":      {false, syscall.MS_SHARED},
+		"rshared":     {false, syscall.MS_SHARED | syscall.MS_REC},
+		"slave":       {false, syscall.MS_SLAVE},
+		"rslave":      {false, syscall.MS_SLAVE | syscall.MS_REC},
+		"unbindable":  {false, syscall.MS_UNBINDABLE},
+		"runbindable": {false, syscall.MS_UNBINDABLE | syscall.MS_REC},
+	}
+
+	var result uintptr
+	data := []string{}
+	for _, option := range options {
+		f, ok := flags[option]
+		switch {
+		case !ok:
+			data = append(data, option)
+		case f.clear:
+			result &^= f.flag
+		default:
+			result |= f.flag
+		}
+	}
+
+	return result, strings.Join(data, ",")
+}
+
+// cleanupFilesys cleans up the filesystem, keeping the upper directory of
+// the overlay when keepUpper is set.
+func cleanupFilesys(rootUUID string, keepUpper bool) {
+	area := filesysPrefix + rootUUID
+	if !keepUpper {
+		os.RemoveAll(area)
+		return
+	}
+	for _, dir := range []string{filesysRoot, filesysWork} {
+		os.RemoveAll(filepath.Join(area, dir))
+	}
+	logrus.Debugf("Keeping upper directory %s", filepath.Join(area, filesysUpper))
+}
+
+const (
+	namespaceSetupFail    = 0x0
+	namespaceSetupSuccess = 0x1
+)
+
+// setupNamespace sets up the namespaces.
+func setupNamespace(fd int, user bool) error {
+	status := namespaceSetupFail
+	if !user {
+		logrus.Debugf("User namespace is not requested")
+	} else if err := syscall.Unshare(syscall.CLONE_NEWUSER); err != nil {
+		logrus.Debugf("Unsharing user namespace is not supported: %s", err)
+	} else {
+		logrus.Debugf("Unsharing user namespace successfully")
+		status = namespaceSetupSuccess
+	}
+	err := syscall.Sendto(fd, []byte{byte(status)}, 0, nil)
+	if err != nil {
+		return err
+	}
+
 	recv := make([]byte, 1)
 	_, _, err = syscall.Recvfrom(fd, recv, 0)
 	if err != nil {
@@ -130,16 +521,32 @@ const (
 	namespaceMapLength = 2000
 )
 
-// mapNamespace maps the namespaces.
-func mapNamespace(pid uintptr) error {
+// mapNamespace maps the namespaces, using a default range when the spec
+// gives no mappings.
+func mapNamespace(pid uintptr, uidMappings []specs.LinuxIDMapping, gidMappings []specs.LinuxIDMapping) error {
 	proc := fmt.Sprintf("/proc/%d", pid)
-	for _, file := range []string{"/uid_map", "/gid_map"} {
-		fd, err := syscall.Creat(proc+file, 0755)
+	defaults := []specs.LinuxIDMapping{{ContainerID: 0, HostID: namespaceMapOffset, Size: namespaceMapLength}}
+	for _, file := range []struct {
+		name     string
+		mappings []specs.LinuxIDMapping
+	}{
+		{"/uid_map", uidMappings},
+		{"/gid_map", gidMappings},
+	} {
+		mappings := file.mappings
+		if len(mappings) == 0 {
+			mappings = defaults
+		}
+
+		fd, err := syscall.Creat(proc+file.name, 0755)
 		if err != nil {
 			return err
 		}
 
-		mapEntry := fmt.Sprintf("%d %d %d", 0, namespaceMapOffset, namespaceMapLength)
+		mapEntry := ""
+		for _, m := range mappings {
+			mapEntry += fmt.Sprintf("%d %d %d\n", m.ContainerID, m.HostID, m.Size)
+		}
 		_, err = syscall.Write(fd, []byte(mapEntry))
 		if err != nil {
 			return err
@@ -157,12 +564,12 @@ func mapNamespace(pid uintptr) error {
 }
 
 // switchNamespace switches the namespaces.
-func switchNamespace(uid int) error {
-	err := syscall.Setgroups([]int{uid})
+func switchNamespace(uid int, gid int) error {
+	err := syscall.Setgroups([]int{gid})
 	if err != nil {
 		return err
 	}
-	err = syscall.Setregid(uid, uid)
+	err = syscall.Setregid(gid, gid)
 	if err != nil {
 		return err
 	}
@@ -171,124 +578,188 @@ func switchNamespace(uid int) error {
 		return err
 	}
 
-	logrus.Debugf("Switching UID/GID to %d successfully", uid)
+	logrus.Debugf("Switching UID/GID to %d/%d successfully", uid, gid)
 	return nil
 }
 
-// setupSyscall sets up the seccomp syscall.
-func setupSyscall() error {
-	filter, err := seccomp.NewFilter(seccomp.ActAllow)
-	if err != nil {
-		return err
+// setupRlimits applies the rlimits of the container process.
+func setupRlimits(rlimits []specs.POSIXRlimit) error {
+	var resources = map[string]int{
+		"RLIMIT_AS":         unix.RLIMIT_AS,
+		"RLIMIT_CORE":       unix.RLIMIT_CORE,
+		"RLIMIT_CPU":        unix.RLIMIT_CPU,
+		"RLIMIT_DATA":       unix.RLIMIT_DATA,
+		"RLIMIT_FSIZE":      unix.RLIMIT_FSIZE,
+		"RLIMIT_LOCKS":      unix.RLIMIT_LOCKS,
+		"RLIMIT_MEMLOCK":    unix.RLIMIT_MEMLOCK,
+		"RLIMIT_MSGQUEUE":   unix.RLIMIT_MSGQUEUE,
+		"RLIMIT_NICE":       unix.RLIMIT_NICE,
+		"RLIMIT_NOFILE":     unix.RLIMIT_NOFILE,
+		"RLIMIT_NPROC":      unix.RLIMIT_NPROC,
+		"RLIMIT_RSS":        unix.RLIMIT_RSS,
+		"RLIMIT_RTPRIO":     unix.RLIMIT_RTPRIO,
+		"RLIMIT_RTTIME":     unix.RLIMIT_RTTIME,
+		"RLIMIT_SIGPENDING": unix.RLIMIT_SIGPENDING,
+		"RLIMIT_STACK":      unix.RLIMIT_STACK,
 	}
 
-	refusedSyscalls := &[]seccomp.ScmpSyscall{
-		seccomp.ScmpSyscall(syscall.SYS_KEYCTL),
-		seccomp.ScmpSyscall(syscall.SYS_ADD_KEY),
-		seccomp.ScmpSyscall(syscall.SYS_REQUEST_KEY),
-		seccomp.ScmpSyscall(syscall.SYS_MBIND),
-		seccomp.ScmpSyscall(syscall.SYS_MIGRATE_PAGES),
-		seccomp.ScmpSyscall(syscall.SYS_MOVE_PAGES),
-		seccomp.ScmpSyscall(syscall.SYS_SET_MEMPOLICY),
-		// seccomp.ScmpSyscall(syscall.SYS_USERFAULTFD),
-		seccomp.ScmpSyscall(syscall.SYS_PERF_EVENT_OPEN),
-	}
-	for _, sc := range *refusedSyscalls {
-		err = filter.AddRule(sc, seccomp.ActErrno.SetReturnCode(int16(syscall.EPERM)))
+	for _, rlimit := range rlimits {
+		resource, ok := resources[rlimit.Type]
+		if !ok {
+			return fmt.Errorf("%w: unknown rlimit %s", ErrInvalidSpec, rlimit.Type)
+		}
+		err := unix.Setrlimit(resource, &unix.Rlimit{Cur: rlimit.Soft, Max: rlimit.Hard})
 		if err != nil {
 			return err
 		}
+		logrus.Debugf("Setting %s to %d/%d", rlimit.Type, rlimit.Soft, rlimit.Hard)
 	}
 
-	refusedCondSyscalls := &[]struct {
-		seccomp.ScmpSyscall
-		uint
-		uint64
-	}{
-		{seccomp.ScmpSyscall(syscall.SYS_CHMOD), 1, syscall.S_ISUID},
-		{seccomp.ScmpSyscall(syscall.SYS_CHMOD), 1, syscall.S_ISGID},
-		{seccomp.ScmpSyscall(syscall.SYS_FCHMOD), 1, syscall.S_ISUID},
-		{seccomp.ScmpSyscall(syscall.SYS_FCHMOD), 1, syscall.S_ISGID},
-		{seccomp.ScmpSyscall(syscall.SYS_FCHMODAT), 2, syscall.S_ISUID},
-		{seccomp.ScmpSyscall(syscall.SYS_FCHMODAT), 2, syscall.S_ISGID},
-		{seccomp.ScmpSyscall(syscall.SYS_UNSHARE), 0, syscall.CLONE_NEWUSER},
-		{seccomp.ScmpSyscall(syscall.SYS_CLONE), 0, syscall.CLONE_NEWUSER},
-		{seccomp.ScmpSyscall(syscall.SYS_IOCTL), 1, syscall.TIOCSTI},
-	}
-	for _, sc := range *refusedCondSyscalls {
-		cond, err := seccomp.MakeCondition(
-			sc.uint,
-			seccomp.CompareMaskedEqual,
-			sc.uint64,
-			sc.uint64,
-		)
+	return nil
+}
+
+// setupSyscall sets up the seccomp syscall filter described by profile.
+func setupSyscall(profile *specs.LinuxSeccomp) error {
+	if profile == nil {
+		logrus.Debugf("Seccomp is disabled")
+		return nil
+	}
+
+	defaultAction, err := seccompAction(profile.DefaultAction, profile.DefaultErrnoRet)
+	if err != nil {
+		return err
+	}
+	filter, err := seccomp.NewFilter(defaultAction)
+	if err != nil {
+		return err
+	}
+	// The syscalls of the architectures the profile does not filter kill the
+	// whole process instead of only the calling thread, which would leave
+	// the other threads of the process running without it.
+	err = filter.SetBadArchAction(seccomp.ActKillProcess)
+	if err != nil {
+		return err
+	}
+
+	for _, arch := range profile.Architectures {
+		scmpArch, err := seccomp.GetArchFromString(strings.TrimPrefix(string(arch), "SCMP_ARCH_"))
 		if err != nil {
 			return err
 		}
-		err = filter.AddRuleConditional(
-			sc.ScmpSyscall,
-			seccomp.ActErrno.SetReturnCode(int16(syscall.EPERM)),
-			[]seccomp.ScmpCondition{cond},
-		)
+		// The syscalls of an architecture libseccomp cannot filter are
+		// killed by the bad-arch action instead.
+		err = filter.AddArch(scmpArch)
+		if err != nil {
+			logrus.Warnf("Fail to add seccomp architecture %s: %s", arch, err)
+		}
+	}
+
+	for _, sc := range profile.Syscalls {
+		action, err := seccompAction(sc.Action, sc.ErrnoRet)
 		if err != nil {
 			return err
 		}
+		if action == defaultAction {
+			continue
+		}
+
+		conds := []seccomp.ScmpCondition{}
+		for _, arg := range sc.Args {
+			cond, err := seccompCondition(arg)
+			if err != nil {
+				return err
+			}
+			conds = append(conds, cond)
+		}
+
+		for _, name := range sc.Names {
+			call, err := seccomp.GetSyscallFromName(name)
+			if err != nil {
+				logrus.Debugf("Skipping unknown syscall %s", name)
+				continue
+			}
+			err = addSyscallRule(filter, call, action, conds)
+			if err != nil {
+				return err
+			}
+		}
 	}
 
 	err = filter.Load()
 	return err
 }
 
-// setupCgroup sets up the cgroup.
-func setupCgroup(hostname string, pid uintptr) (cgroup1.Cgroup, error) {
-	var (
-		cgroupRLimit       uint64 = 64
-		cgroupCPUShare     uint64 = 256
-		cgroupMemoryLimit  int64  = 1024 * 1024 * 1024
-		cgroupMemoryKernel int64  = 1024 * 1024 * 1024
-		cgroupPidLimit     int64  = 64
-		cgroupBlkIOWeight  uint16 = 50
-	)
-
-	err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &syscall.Rlimit{
-		Cur: cgroupRLimit,
-		Max: cgroupRLimit,
-	})
-	if err != nil {
-		return nil, err
-	}
-
-	control, err := cgroup1.New(cgroup1.StaticPath(hostname), &specs.LinuxResources{
-		CPU: &specs.LinuxCPU{
-			Shares: &cgroupCPUShare,
-		},
-		Memory: &specs.LinuxMemory{
-			Limit:  &cgroupMemoryLimit,
-			Kernel: &cgroupMemoryKernel,
-		},
-		Pids: &specs.LinuxPids{
-			Limit: cgroupPidLimit,
-		},
-		BlockIO: &specs.LinuxBlockIO{
-			Weight: &cgroupBlkIOWeight,
-		},
-	})
-	if err != nil {
-		return nil, err
+// addSyscallRule adds a rule for a syscall to filter. Conditions on the same
+// argument cannot be combined by libseccomp and are added as separate rules.
+func addSyscallRule(filter *seccomp.ScmpFilter, call seccomp.ScmpSyscall, action seccomp.ScmpAction, conds []seccomp.ScmpCondition) error {
+	if len(conds) == 0 {
+		return filter.AddRule(call, action)
 	}
 
-	err = control.Add(cgroup1.Process{
-		Pid: int(pid),
-	})
-	if err != nil {
-		control.Delete()
-		return nil, err
+	seen := map[uint]bool{}
+	for _, cond := range conds {
+		if seen[cond.Argument] {
+			for _, cond := range conds {
+				err := filter.AddRuleConditional(call, action, []seccomp.ScmpCondition{cond})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		seen[cond.Argument] = true
 	}
 
-	return control, nil
+	return filter.AddRuleConditional(call, action, conds)
 }
 
-// cleanupCgroup cleans up the cgroup.
-func cleanupCgroup(control cgroup1.Cgroup) {
-	control.Delete()
+// seccompAction converts a spec seccomp action into a libseccomp action.
+func seccompAction(action specs.LinuxSeccompAction, errnoRet *uint) (seccomp.ScmpAction, error) {
+	errno := int16(syscall.EPERM)
+	if errnoRet != nil {
+		errno = int16(*errnoRet)
+	}
+
+	switch action {
+	case specs.ActKill, specs.ActKillThread:
+		return seccomp.ActKillThread, nil
+	case specs.ActKillProcess:
+		return seccomp.ActKillProcess, nil
+	case specs.ActTrap:
+		return seccomp.ActTrap, nil
+	case specs.ActErrno:
+		return seccomp.ActErrno.SetReturnCode(errno), nil
+	case specs.ActTrace:
+		return seccomp.ActTrace.SetReturnCode(errno), nil
+	case specs.ActAllow:
+		return seccomp.ActAllow, nil
+	case specs.ActLog:
+		return seccomp.ActLog, nil
+	case specs.ActNotify:
+	}
+
+	return seccomp.ActInvalid, fmt.Errorf("%w: unsupported seccomp action %s", ErrInvalidSpec, action)
+}
+
+// seccompCondition converts a spec seccomp argument into a libseccomp condition.
+func seccompCondition(arg specs.LinuxSeccompArg) (seccomp.ScmpCondition, error) {
+	var ops = map[specs.LinuxSeccompOperator]seccomp.ScmpCompareOp{
+		specs.OpNotEqual:     seccomp.CompareNotEqual,
+		specs.OpLessThan:     seccomp.CompareLess,
+		specs.OpLessEqual:    seccomp.CompareLessOrEqual,
+		specs.OpEqualTo:      seccomp.CompareEqual,
+		specs.OpGreaterEqual: seccomp.CompareGreaterEqual,
+		specs.OpGreaterThan:  seccomp.CompareGreater,
+		specs.OpMaskedEqual:  seccomp.CompareMaskedEqual,
+	}
+
+	op, ok := ops[arg.Op]
+	if !ok {
+		return seccomp.ScmpCondition{}, fmt.Errorf("%w: unsupported seccomp operator %s", ErrInvalidSpec, arg.Op)
+	}
+	if op == seccomp.CompareMaskedEqual {
+		return seccomp.MakeCondition(arg.Index, op, arg.Value, arg.ValueTwo)
+	}
+
+	return seccomp.MakeCondition(arg.Index, op, arg.Value)
 }
@@ -0,0 +1,75 @@
+package runtime
+
+import (
+	"reflect"
+	"syscall"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func TestParseMountOptions(t *testing.T) {
+	tests := []struct {
+		options []string
+		flags   uintptr
+		data    string
+	}{
+		{nil, 0, ""},
+		{[]string{"ro", "nosuid", "size=64k"}, syscall.MS_RDONLY | syscall.MS_NOSUID, "size=64k"},
+		{[]string{"ro", "rw"}, 0, ""},
+		{[]string{"rbind", "rslave", "mode=755", "uid=0"}, syscall.MS_BIND | syscall.MS_REC | syscall.MS_SLAVE, "mode=755,uid=0"},
+	}
+
+	for _, tt := range tests {
+		flags, data := parseMountOptions(tt.options)
+		if flags != tt.flags || data != tt.data {
+			t.Errorf("parseMountOptions(%q) = %#x, %q, want %#x, %q", tt.options, flags, data, tt.flags, tt.data)
+		}
+	}
+}
+
+func TestSplitPropagation(t *testing.T) {
+	tests := []struct {
+		options     []string
+		rest        []string
+		propagation uintptr
+	}{
+		{[]string{"rbind", "ro"}, []string{"rbind", "ro"}, 0},
+		{[]string{"rbind", "shared", "ro"}, []string{"rbind", "ro"}, syscall.MS_SHARED},
+		{[]string{"rprivate", "rslave"}, []string{}, syscall.MS_SLAVE | syscall.MS_REC},
+	}
+
+	for _, tt := range tests {
+		rest, propagation := splitPropagation(tt.options)
+		if !reflect.DeepEqual(rest, tt.rest) || propagation != tt.propagation {
+			t.Errorf("splitPropagation(%q) = %q, %#x, want %q, %#x", tt.options, rest, propagation, tt.rest, tt.propagation)
+		}
+	}
+}
+
+func TestRootPropagation(t *testing.T) {
+	bind := func(propagation string) specs.Mount {
+		return specs.Mount{Destination: "/v", Type: "bind", Source: "/v", Options: []string{"rbind", propagation}}
+	}
+	tests := []struct {
+		given  string
+		mounts []specs.Mount
+		want   string
+	}{
+		{"", nil, "rprivate"},
+		{"", []specs.Mount{bind("rprivate")}, "rprivate"},
+		{"", []specs.Mount{bind("rslave")}, "rslave"},
+		{"", []specs.Mount{bind("slave"), bind("rprivate")}, "rslave"},
+		{"", []specs.Mount{bind("rslave"), bind("shared")}, "rslave"},
+		{"", []specs.Mount{bind("rshared")}, "rslave"},
+		{"", []specs.Mount{{Destination: "/t", Type: "tmpfs", Source: "tmpfs", Options: []string{"shared"}}}, "rprivate"},
+		{"private", []specs.Mount{bind("rshared")}, "private"},
+	}
+
+	for _, tt := range tests {
+		spec := &specs.Spec{Mounts: tt.mounts, Linux: &specs.Linux{RootfsPropagation: tt.given}}
+		if got := rootPropagation(spec); got != tt.want {
+			t.Errorf("rootPropagation(%q, %v) = %q, want %q", tt.given, tt.mounts, got, tt.want)
+		}
+	}
+}
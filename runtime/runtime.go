@@ -2,213 +2,521 @@ package runtime
 
 import (
 	"fmt"
+	"net"
 	"os"
-	"strconv"
 	"strings"
 	"syscall"
 
 	"github.com/google/uuid"
 	"github.com/msaf1980/go-uname"
+	"github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/sirupsen/logrus"
 )
 
 const minimalKernelVersion = 4.8
 
 type Runtime struct {
-	command  string
-	args     []string
-	uid      int
-	root     string
+	id       string
+	bundle   string
+	spec     *specs.Spec
 	hostname string
 	uuid     string
-	volumes  []VolumePair
+
+	pid     uintptr
+	sockets [2]int
+	mounted bool
+	control cgroup
+	ptmx    *os.File
+	pts     *os.File
+	stdio   *stdio
+	initExe *os.File
+	network *network
+	proxy   *portProxy
 }
 
 type VolumePair struct {
-	Source string
-	Target string
+	Source  string   `json:"source"`
+	Target  string   `json:"target"`
+	Options []string `json:"options,omitempty"`
 }
 
-// New creates a new container with the given command and arguments.
-func New(command string, args []string, uid int, root string, volumes []VolumePair) (*Runtime, error) {
-	u, err := uname.New()
+// Options configure a container started from the command line.
+type Options struct {
+	// UID is the user the command runs as when neither User nor the image
+	// gives one.
+	UID int
+	// User is the user the command runs as, given as user[:group] by name
+	// or ID.
+	User string
+	// Entrypoint replaces the entrypoint of the image when not nil.
+	Entrypoint []string
+	// Env are the variables set in the environment of the command, given as
+	// NAME=VALUE or as NAME to pass the value of the caller.
+	Env []string
+	// Workdir is the directory the command runs in.
+	Workdir string
+	// Root is the directory mounted as the root of the container, or the
+	// name or ID of an imported image.
+	Root string
+	// Image is the name or ID of an imported image used as the root.
+	Image string
+	// Volumes are the host directories mounted into the container.
+	Volumes []VolumePair
+	// Mounts are the filesystems mounted into the container, after the
+	// volumes.
+	Mounts []Mount
+	// NoProc, NoSysfs, NoDevpts and NoShm disable the mounts of /proc, /sys,
+	// /dev/pts and /dev/shm.
+	NoProc   bool
+	NoSysfs  bool
+	NoDevpts bool
+	NoShm    bool
+	// Devices are the host devices passed through to the container.
+	Devices []Device
+	// Seccomp is the path of the seccomp profile of the container, in the
+	// format of Docker or of the spec, or SeccompUnconfined to disable the
+	// filter. The default profile is used when empty.
+	Seccomp string
+	// SeccompLegacy uses the former default profile, which only denies a few
+	// syscalls, instead of the default allowlist.
+	SeccompLegacy bool
+	// SeccompLearn is the path where a profile allowing only the syscalls
+	// made by the container is written once it exits, the container running
+	// without restrictions meanwhile.
+	SeccompLearn string
+	// Resources are the limits of the container.
+	Resources Resources
+	// Init runs the command under a minimal init process.
+	Init bool
+	// Network is the network mode, one of NetworkNone, NetworkHost and
+	// NetworkBridge.
+	Network string
+	// Ports are the container ports published on the host.
+	Ports []PortMapping
+	// PublishAll publishes every port exposed by the image on the same port
+	// of the host.
+	PublishAll bool
+	// Remove discards the container along with the upper layer of its root
+	// filesystem once it exits, instead of keeping them for inspection.
+	Remove bool
+}
+
+// New creates a new container with the given command and arguments, which
+// may be empty when an image gives them.
+func New(command string, args []string, opts *Options) (*Runtime, error) {
+	err := opts.Resources.Validate()
 	if err != nil {
 		return nil, err
 	}
-	logrus.Debugf("Detecting OS: %s %s %s %s", u.Sysname(), u.Nodename(), u.KernelRelease(), u.Machine())
-	if u.Machine() != "x86_64" {
-		return nil, ErrUnsupportedArch
+	if opts.Network != NetworkNone && opts.Network != NetworkHost && opts.Network != NetworkBridge {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidNetwork, opts.Network)
 	}
-	if u.Sysname() != "Linux" {
-		return nil, ErrUnsupportedOS
+	for _, v := range opts.Volumes {
+		err = v.validate()
+		if err != nil {
+			return nil, err
+		}
+		err = checkPropagation(v.Source, v.Options)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidVolume, err)
+		}
 	}
-	var major, minor float32
-	_, err = fmt.Sscanf(u.KernelRelease(), "%f.%f", &major, &minor)
+	for _, m := range opts.Mounts {
+		err = m.validate()
+		if err != nil {
+			return nil, err
+		}
+		if m.Type == MountBind {
+			err = checkPropagation(m.Source, m.Options)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidMount, err)
+			}
+		}
+	}
+	for _, d := range opts.Devices {
+		err = d.validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, m := range opts.Ports {
+		err = m.validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if (len(opts.Ports) > 0 || opts.PublishAll) && opts.Network == NetworkHost {
+		return nil, fmt.Errorf("%w: ports cannot be published on the host network", ErrInvalidPort)
+	}
+	err = checkPlatform()
 	if err != nil {
 		return nil, err
 	}
-	if major < minimalKernelVersion {
-		return nil, ErrUnsupportedVersion
+
+	// A root that is not a directory refers to an imported image.
+	ref := opts.Image
+	if ref == "" && opts.Root != "" {
+		info, err := os.Stat(opts.Root)
+		if err != nil || !info.IsDir() {
+			ref = opts.Root
+		}
+	} else if ref != "" && opts.Root != "" {
+		return nil, fmt.Errorf("%w: root and image are mutually exclusive", ErrInvalidSpec)
 	}
 
-	hostname, err := newHostname()
+	argv := []string{}
+	if command != "" {
+		argv = append([]string{command}, args...)
+	}
+	id := uuid.NewString()
+	spec := defaultSpec(argv, opts)
+	for _, m := range opts.Mounts {
+		sm, err := m.specMount()
+		if err != nil {
+			return nil, err
+		}
+		spec.Mounts = append(spec.Mounts, sm)
+	}
+	sortMounts(spec.Mounts)
+	spec.Linux.Seccomp, err = containerSeccomp(opts)
 	if err != nil {
 		return nil, err
 	}
+	for _, d := range opts.Devices {
+		device, rule, err := d.linuxDevice()
+		if err != nil {
+			return nil, err
+		}
+		spec.Linux.Devices = append(spec.Linux.Devices, device)
+		spec.Linux.Resources.Devices = append(spec.Linux.Resources.Devices, rule)
+	}
+	layers := []string{spec.Root.Path}
+	if ref != "" {
+		img, err := LookupImage(ref)
+		if err != nil {
+			return nil, err
+		}
+		err = useImage(spec, ref, img)
+		if err != nil {
+			return nil, err
+		}
+		config, err := readImageConfig(img)
+		if err != nil {
+			return nil, err
+		}
+		err = applyImageConfig(spec, &config.Config, argv, opts)
+		if err != nil {
+			return nil, err
+		}
+		layers = strings.Split(spec.Annotations[annotationLayers], ":")
+	}
+	if opts.User != "" {
+		spec.Process.User, err = resolveUser(layers, opts.User)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(spec.Process.Args) == 0 {
+		return nil, fmt.Errorf("%w: no command given", ErrInvalidSpec)
+	}
+
+	return newRuntime(id, "", spec)
+}
+
+// newRuntime creates a new container from an already validated spec.
+func newRuntime(id string, bundle string, spec *specs.Spec) (*Runtime, error) {
+	hostname := spec.Hostname
+	if hostname == "" {
+		var err error
+		hostname, err = newHostname()
+		if err != nil {
+			return nil, err
+		}
+	}
 	logrus.Debugf("Using hostname: %s", hostname)
 
 	uuid := uuid.NewString()
 
 	return &Runtime{
-		command:  command,
-		args:     args,
-		uid:      uid,
-		root:     root,
+		id:       id,
+		bundle:   bundle,
+		spec:     spec,
 		hostname: hostname,
 		uuid:     uuid,
-		volumes:  volumes,
+		sockets:  [2]int{-1, -1},
 	}, nil
 }
 
-// Run executes the container's command with the given arguments.
-func (r *Runtime) Run() (int, error) {
-	sockets, err := newSocketPair()
+// checkPlatform checks that the host is able to run containers.
+func checkPlatform() error {
+	u, err := uname.New()
 	if err != nil {
-		return 0, err
+		return err
 	}
-	logrus.Debugf("Creating socket pair: %d %d", sockets[0], sockets[1])
-	defer cleanupSocketPair(sockets)
-
-	pid, err := spawnChild(r, sockets[1])
-	if err != nil {
-		return 0, err
+	logrus.Debugf("Detecting OS: %s %s %s %s", u.Sysname(), u.Nodename(), u.KernelRelease(), u.Machine())
+	if u.Machine() != "x86_64" {
+		return ErrUnsupportedArch
 	}
-	logrus.Debugf("Spawning container with PID %d", pid)
-	recv := make([]byte, 1)
-	_, _, err = syscall.Recvfrom(sockets[0], recv, 0)
+	if u.Sysname() != "Linux" {
+		return ErrUnsupportedOS
+	}
+	var major, minor float32
+	_, err = fmt.Sscanf(u.KernelRelease(), "%f.%f", &major, &minor)
 	if err != nil {
-		return 0, err
+		return err
 	}
-	if recv[0] == filesysMountFail {
-		logrus.Debugf("Mounting filesystem in child failed")
-	} else {
-		logrus.Debugf("Mounting filesystem in child successfully")
-		defer cleanupFilesys(r.uuid)
+	if major < minimalKernelVersion {
+		return ErrUnsupportedVersion
 	}
 
-	// * Setup cgroup may not working in some platform
-	// control, err := setupCgroup(r.hostname, pid)
-	// if err != nil {
-	// 	return 0, err
-	// }
-	// logrus.Debugf("Setting up cgroup: %s", control)
-	// defer cleanupCgroup(control)
+	return nil
+}
 
-	_, _, err = syscall.Recvfrom(sockets[0], recv, 0)
+// Run executes the container's command with the given arguments.
+func (r *Runtime) Run() (int, error) {
+	return r.run(nil)
+}
+
+// Exec executes the container's command with the given arguments attached to
+// the standard streams, through a pseudo-terminal when stdin is a terminal
+// and through pipes otherwise.
+func (r *Runtime) Exec() (int, error) {
+	r.spec.Process.Terminal = isTerminal(os.Stdin)
+	if !r.spec.Process.Terminal {
+		stdio, err := newPipes()
+		if err != nil {
+			return 0, err
+		}
+		r.stdio = stdio
+	}
+
+	return r.run(func() (func(), error) {
+		if r.spec.Process.Terminal {
+			return attachConsole(r.ptmx)
+		}
+		return attachPipes(r.stdio), nil
+	})
+}
+
+// run executes the container in the foreground, recording its state. When
+// attach is set, it is called before the user command is executed and the
+// function it returns once the container has exited.
+func (r *Runtime) run(attach func() (func(), error)) (int, error) {
+	defer r.cleanup()
+	st, err := r.register()
 	if err != nil {
 		return 0, err
 	}
-	if recv[0] == namespaceSetupFail {
-		logrus.Debugf("Unsharing user namespace from child failed")
-	} else {
-		logrus.Debugf("Unsharing user namespace from child successfully")
-		err = mapNamespace(pid)
+	err = r.spawn()
+	if err == nil {
+		err = markCreated(st, r)
+	}
+	if err != nil {
+		r.kill()
+		markStopped(st, -1)
+		return 0, err
+	}
+	defer forwardSignals(r.pid)()
+
+	if attach != nil {
+		detach, err := attach()
 		if err != nil {
+			r.kill()
+			markStopped(st, -1)
 			return 0, err
 		}
+		defer detach()
 	}
-	err = syscall.Sendto(sockets[0], []byte{0x0}, 0, nil)
+	if path := r.spec.Annotations[annotationSeccompLearn]; path != "" {
+		learner := startSeccompLearner(r.pid)
+		defer func() {
+			err := learner.stop(path)
+			if err != nil {
+				logrus.Errorf("Fail to write seccomp profile: %s", err)
+				return
+			}
+			logrus.Infof("Writing seccomp profile %s", path)
+		}()
+	}
+	err = r.release()
 	if err != nil {
+		r.kill()
+		markStopped(st, -1)
 		return 0, err
 	}
+	markRunning(st)
 
-	stat, err := waitChild(pid)
+	stat, err := waitChild(r.pid)
 	if err != nil {
+		markStopped(st, -1)
 		return 0, err
 	}
+	markStopped(st, stat)
 
 	return stat, nil
 }
 
-// Exec executes the container's command with the given arguments and forwards
-// the standard input, output and error streams.
-func (r *Runtime) Exec() (int, error) {
+// spawn creates the container process and drives it through the setup
+// handshake, stopping right before the user command is executed.
+func (r *Runtime) spawn() error {
 	sockets, err := newSocketPair()
 	if err != nil {
-		return 0, err
+		return err
 	}
+	r.sockets = sockets
 	logrus.Debugf("Creating socket pair: %d %d", sockets[0], sockets[1])
-	defer cleanupSocketPair(sockets)
 
-	pid, err := spawnChild(r, sockets[1])
+	flags, err := cloneFlags(r.spec.Linux.Namespaces)
 	if err != nil {
-		return 0, err
+		return err
+	}
+	if r.spec.Annotations[annotationInit] == "true" {
+		r.initExe, err = os.Open("/proc/self/exe")
+		if err != nil {
+			return err
+		}
+	}
+	if r.spec.Process.Terminal {
+		r.ptmx, r.pts, err = newConsole()
+		if err != nil {
+			return err
+		}
+		logrus.Debugf("Allocating console: %s", r.pts.Name())
+	}
+	pid, err := spawnChild(r, flags, sockets[1])
+	if err != nil {
+		return err
+	}
+	r.pid = pid
+	if r.pts != nil {
+		r.pts.Close()
+		r.pts = nil
+	}
+	if r.stdio != nil {
+		r.stdio.closeChild()
 	}
 	logrus.Debugf("Spawning container with PID %d", pid)
 	recv := make([]byte, 1)
 	_, _, err = syscall.Recvfrom(sockets[0], recv, 0)
 	if err != nil {
-		return 0, err
+		return err
 	}
 	if recv[0] == filesysMountFail {
 		logrus.Debugf("Mounting filesystem in child failed")
 	} else {
 		logrus.Debugf("Mounting filesystem in child successfully")
-		defer cleanupFilesys(r.uuid)
+		r.mounted = true
+	}
+
+	control, err := setupCgroup(cgroupPath(r.spec, r.id), r.spec.Linux.Resources, pid)
+	if err != nil {
+		return err
+	}
+	if control != nil {
+		r.control = control
+		logrus.Debugf("Setting up cgroup: %s", control.path())
 	}
 
-	// * Setup cgroup may not working in some platform
-	// control, err := setupCgroup(r.hostname, pid)
-	// if err != nil {
-	// 	return 0, err
-	// }
-	// logrus.Debugf("Setting up cgroup: %s", control)
-	// defer cleanupCgroup(control)
+	mode := networkMode(r.spec)
+	switch mode {
+	case NetworkBridge:
+		r.network, err = setupNetwork(r.id, r.uuid, pid)
+	case NetworkNone:
+		err = setupLoopback(pid)
+	}
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Using %s network", mode)
+
+	mappings := portMappings(r.spec)
+	if len(mappings) > 0 && mode != NetworkHost {
+		target := net.IPv4(127, 0, 0, 1)
+		if r.network != nil {
+			target = r.network.ipv4.IP
+		}
+		r.proxy, err = startPortProxy(mappings, pid, target)
+		if err != nil {
+			return err
+		}
+	}
 
 	_, _, err = syscall.Recvfrom(sockets[0], recv, 0)
 	if err != nil {
-		return 0, err
+		return err
 	}
 	if recv[0] == namespaceSetupFail {
 		logrus.Debugf("Unsharing user namespace from child failed")
 	} else {
 		logrus.Debugf("Unsharing user namespace from child successfully")
-		err = mapNamespace(pid)
+		err = mapNamespace(pid, r.spec.Linux.UIDMappings, r.spec.Linux.GIDMappings)
 		if err != nil {
-			return 0, err
+			return err
 		}
 	}
-	err = syscall.Sendto(sockets[0], []byte{0x0}, 0, nil)
-	if err != nil {
-		return 0, err
+
+	return nil
+}
+
+// kill kills and reaps the spawned container process, which would
+// otherwise wait forever for the end of the setup handshake.
+func (r *Runtime) kill() {
+	if r.pid != 0 {
+		syscall.Kill(int(r.pid), syscall.SIGKILL)
+		waitChild(r.pid)
 	}
+}
 
-	stdin, err := os.Create("/proc/" + strconv.Itoa(int(pid)) + "/fd/0")
-	if err != nil {
-		return 0, err
+// release lets the spawned container execute the user command.
+func (r *Runtime) release() error {
+	return syscall.Sendto(r.sockets[0], []byte{0x0}, 0, nil)
+}
+
+// cleanup releases the host resources held by the container.
+func (r *Runtime) cleanup() {
+	if r.sockets[0] >= 0 {
+		cleanupSocketPair(r.sockets)
+		r.sockets = [2]int{-1, -1}
 	}
-	defer stdin.Close()
-	for {
-		buf := []byte{}
-		_, err := os.Stdin.Read(buf)
-		if err != nil {
-			break
-		}
-		_, err = stdin.Write(buf)
-		if err != nil {
-			break
+	remove := r.spec.Annotations[annotationRemove] == "true"
+	if r.mounted {
+		cleanupFilesys(r.uuid, r.spec.Annotations[annotationOverlay] == "true" && !remove)
+		r.mounted = false
+	}
+	if r.proxy != nil {
+		r.proxy.close()
+		r.proxy = nil
+	}
+	if r.network != nil {
+		r.network.teardown()
+		r.network = nil
+	}
+	if r.control != nil {
+		cleanupCgroup(r.control)
+		r.control = nil
+	}
+	for _, f := range []*os.File{r.ptmx, r.pts, r.initExe} {
+		if f != nil {
+			f.Close()
 		}
 	}
-
-	stat, err := waitChild(pid)
-	if err != nil {
-		return 0, err
+	r.ptmx, r.pts, r.initExe = nil, nil, nil
+	if r.stdio != nil {
+		r.stdio.close()
+		r.stdio = nil
+	}
+	if remove {
+		logrus.Debugf("Removing container %s", r.id)
+		os.RemoveAll(statePath(r.id, ""))
 	}
+}
 
-	return stat, nil
+// ID returns the ID of the container.
+func (r *Runtime) ID() string {
+	return r.id
 }
 
 // String returns a string representation of the container.
 func (r *Runtime) String() string {
-	return fmt.Sprintf("%s %s", r.command, strings.Join(r.args, " "))
+	return strings.Join(r.spec.Process.Args, " ")
 }
@@ -0,0 +1,244 @@
+package runtime
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/msaf1980/go-uname"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// SeccompUnconfined disables the seccomp filter of a container.
+const SeccompUnconfined = "unconfined"
+
+const (
+	seccompNativeArch     = "amd64"
+	seccompNativeScmpArch = specs.ArchX86_64
+)
+
+var (
+	//go:embed seccomp.json
+	seccompDefaultProfile []byte
+	//go:embed seccomp_legacy.json
+	seccompLegacyProfile []byte
+)
+
+// seccompProfile is a seccomp profile in the format of Docker, which extends
+// the one of the spec with rules depending on the host and the container.
+type seccompProfile struct {
+	DefaultAction   specs.LinuxSeccompAction `json:"defaultAction"`
+	DefaultErrnoRet *uint                    `json:"defaultErrnoRet,omitempty"`
+	// Architectures and the ones mapped to the one of the host are filtered
+	// with the rules of the profile, while the syscalls of any other ABI of
+	// the host are denied.
+	Architectures []specs.Arch     `json:"architectures,omitempty"`
+	ArchMap       []seccompArchMap `json:"archMap,omitempty"`
+	Syscalls      []seccompSyscall `json:"syscalls"`
+}
+
+// seccompArchMap lists the architectures filtered along with an
+// architecture of the host.
+type seccompArchMap struct {
+	Arch      specs.Arch   `json:"architecture"`
+	SubArches []specs.Arch `json:"subArchitectures"`
+}
+
+// seccompSyscall is a rule of a profile, applied only when the host and the
+// container match its includes and none of its excludes.
+type seccompSyscall struct {
+	specs.LinuxSyscall
+	// Name is the single syscall of profiles predating names.
+	Name     string        `json:"name,omitempty"`
+	Includes seccompFilter `json:"includes"`
+	Excludes seccompFilter `json:"excludes"`
+}
+
+// seccompFilter restricts the rule of a profile to some architectures, to
+// containers with some capabilities, or to recent kernels.
+type seccompFilter struct {
+	Arches    []string `json:"arches,omitempty"`
+	Caps      []string `json:"caps,omitempty"`
+	MinKernel string   `json:"minKernel,omitempty"`
+}
+
+// containerSeccomp returns the seccomp filter of a container started from
+// the command line. Rules depending on capabilities are chosen for the ones
+// Docker grants by default, the filter standing in for the capabilities the
+// runtime does not drop. Containers learning their syscalls get no filter
+// from the spec.
+func containerSeccomp(opts *Options) (*specs.LinuxSeccomp, error) {
+	if opts.SeccompLearn != "" {
+		if opts.SeccompLegacy || opts.Seccomp != "" {
+			return nil, fmt.Errorf("%w: learning syscalls excludes any other profile", ErrInvalidSeccomp)
+		}
+		return nil, nil
+	}
+	if opts.SeccompLegacy {
+		if opts.Seccomp != "" {
+			return nil, fmt.Errorf("%w: legacy and %s profiles are mutually exclusive", ErrInvalidSeccomp, opts.Seccomp)
+		}
+		return parseSeccomp("legacy", seccompLegacyProfile, defaultCapabilities())
+	}
+	return loadSeccomp(opts.Seccomp, defaultCapabilities())
+}
+
+// loadSeccomp returns the seccomp filter of the spec described by the
+// profile at the given path, by the default profile when the path is empty,
+// or nil for SeccompUnconfined. Capabilities are the ones of the container,
+// nil meaning that it keeps all of them.
+func loadSeccomp(path string, capabilities []string) (*specs.LinuxSeccomp, error) {
+	switch path {
+	case SeccompUnconfined:
+		return nil, nil
+	case "":
+		return parseSeccomp("default", seccompDefaultProfile, capabilities)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseSeccomp(path, data, capabilities)
+}
+
+// parseSeccomp returns the seccomp filter of the spec described by the
+// named profile.
+func parseSeccomp(name string, data []byte, capabilities []string) (*specs.LinuxSeccomp, error) {
+	profile := &seccompProfile{}
+	err := json.Unmarshal(data, profile)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %s", ErrInvalidSeccomp, name, err)
+	}
+	seccomp, err := profile.linuxSeccomp(capabilities)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %s", ErrInvalidSeccomp, name, err)
+	}
+
+	return seccomp, nil
+}
+
+// defaultCapabilities returns the capabilities Docker grants to containers
+// by default.
+func defaultCapabilities() []string {
+	return []string{
+		"CAP_CHOWN",
+		"CAP_DAC_OVERRIDE",
+		"CAP_FSETID",
+		"CAP_FOWNER",
+		"CAP_MKNOD",
+		"CAP_NET_RAW",
+		"CAP_SETGID",
+		"CAP_SETUID",
+		"CAP_SETFCAP",
+		"CAP_SETPCAP",
+		"CAP_NET_BIND_SERVICE",
+		"CAP_SYS_CHROOT",
+		"CAP_KILL",
+		"CAP_AUDIT_WRITE",
+	}
+}
+
+// linuxSeccomp returns the filter of the spec made of the rules of the
+// profile that apply to the host and to a container with the given
+// capabilities.
+func (p *seccompProfile) linuxSeccomp(capabilities []string) (*specs.LinuxSeccomp, error) {
+	_, err := seccompAction(p.DefaultAction, p.DefaultErrnoRet)
+	if err != nil {
+		return nil, err
+	}
+	seccomp := &specs.LinuxSeccomp{
+		DefaultAction:   p.DefaultAction,
+		DefaultErrnoRet: p.DefaultErrnoRet,
+		Architectures:   p.Architectures,
+		Syscalls:        []specs.LinuxSyscall{},
+	}
+	for _, m := range p.ArchMap {
+		if m.Arch == seccompNativeScmpArch {
+			seccomp.Architectures = append(append(seccomp.Architectures, m.Arch), m.SubArches...)
+		}
+	}
+
+	for _, sc := range p.Syscalls {
+		if sc.Name != "" {
+			sc.Names = append(sc.Names, sc.Name)
+		}
+		if len(sc.Names) == 0 {
+			return nil, fmt.Errorf("rule without syscall names")
+		}
+		_, err = seccompAction(sc.Action, sc.ErrnoRet)
+		if err != nil {
+			return nil, err
+		}
+		for _, arg := range sc.Args {
+			_, err = seccompCondition(arg)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		included, err := sc.Includes.matches(capabilities, true)
+		if err != nil {
+			return nil, err
+		}
+		excluded, err := sc.Excludes.matches(capabilities, false)
+		if err != nil {
+			return nil, err
+		}
+		if included && !excluded {
+			seccomp.Syscalls = append(seccomp.Syscalls, sc.LinuxSyscall)
+		}
+	}
+
+	return seccomp, nil
+}
+
+// matches reports whether the host and a container with the given
+// capabilities match every condition of the filter when all is set, or any
+// of them otherwise. A filter without conditions matches only when all is
+// set, so that empty includes keep a rule and empty excludes do not drop it.
+func (f seccompFilter) matches(capabilities []string, all bool) (bool, error) {
+	results := []bool{}
+	if len(f.Arches) > 0 {
+		results = append(results, slices.Contains(f.Arches, seccompNativeArch))
+	}
+	for _, c := range f.Caps {
+		results = append(results, capabilities == nil || slices.Contains(capabilities, c))
+	}
+	if f.MinKernel != "" {
+		recent, err := kernelAtLeast(f.MinKernel)
+		if err != nil {
+			return false, err
+		}
+		results = append(results, recent)
+	}
+
+	for _, result := range results {
+		if result != all {
+			return !all, nil
+		}
+	}
+	return all, nil
+}
+
+// kernelAtLeast reports whether the kernel of the host is at least the
+// given major.minor version.
+func kernelAtLeast(version string) (bool, error) {
+	var major, minor, hostMajor, hostMinor int
+	_, err := fmt.Sscanf(version, "%d.%d", &major, &minor)
+	if err != nil {
+		return false, fmt.Errorf("invalid kernel version %q", version)
+	}
+	u, err := uname.New()
+	if err != nil {
+		return false, err
+	}
+	_, err = fmt.Sscanf(u.KernelRelease(), "%d.%d", &hostMajor, &hostMinor)
+	if err != nil {
+		return false, err
+	}
+
+	return hostMajor > major || (hostMajor == major && hostMinor >= minor), nil
+}
@@ -0,0 +1,176 @@
+package runtime
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+const seccompTestProfile = `{
+	"defaultAction": "SCMP_ACT_ERRNO",
+	"archMap": [
+		{"architecture": "SCMP_ARCH_X86_64", "subArchitectures": ["SCMP_ARCH_X86"]},
+		{"architecture": "SCMP_ARCH_AARCH64", "subArchitectures": ["SCMP_ARCH_ARM"]}
+	],
+	"syscalls": [
+		{"names": ["read"], "action": "SCMP_ACT_ALLOW"},
+		{"name": "write", "action": "SCMP_ACT_ALLOW"},
+		{"names": ["reboot"], "action": "SCMP_ACT_ALLOW", "includes": {"caps": ["CAP_SYS_BOOT"]}},
+		{"names": ["chown"], "action": "SCMP_ACT_ALLOW", "includes": {"caps": ["CAP_CHOWN"]}},
+		{"names": ["arch_prctl"], "action": "SCMP_ACT_ALLOW", "includes": {"arches": ["amd64", "x32"]}},
+		{"names": ["sync_file_range2"], "action": "SCMP_ACT_ALLOW", "includes": {"arches": ["ppc64le"]}},
+		{"names": ["unshare"], "action": "SCMP_ACT_ALLOW", "excludes": {"caps": ["CAP_SYS_ADMIN"]}},
+		{"names": ["ptrace"], "action": "SCMP_ACT_ALLOW", "includes": {"minKernel": "1.0"}},
+		{"names": ["future"], "action": "SCMP_ACT_ALLOW", "includes": {"minKernel": "999.0"}},
+		{"names": ["personality"], "action": "SCMP_ACT_ALLOW", "excludes": {"arches": ["amd64"]}},
+		{"names": ["mount"], "action": "SCMP_ACT_ALLOW", "includes": {"caps": ["CAP_SYS_ADMIN"], "arches": ["amd64"]}}
+	]
+}`
+
+// seccompNames returns the syscalls of the rules of a filter, in order.
+func seccompNames(seccomp *specs.LinuxSeccomp) []string {
+	names := []string{}
+	for _, sc := range seccomp.Syscalls {
+		names = append(names, sc.Names...)
+	}
+	return names
+}
+
+// writeSeccomp writes a profile to a file and returns its path.
+func writeSeccomp(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "seccomp.json")
+	err := os.WriteFile(path, []byte(data), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadSeccomp(t *testing.T) {
+	tests := []struct {
+		capabilities []string
+		want         []string
+	}{
+		{[]string{"CAP_CHOWN"}, []string{"read", "write", "chown", "arch_prctl", "unshare", "ptrace"}},
+		{[]string{"CAP_SYS_ADMIN"}, []string{"read", "write", "arch_prctl", "ptrace", "mount"}},
+		{nil, []string{"read", "write", "reboot", "chown", "arch_prctl", "ptrace", "mount"}},
+	}
+
+	path := writeSeccomp(t, seccompTestProfile)
+	for _, tt := range tests {
+		seccomp, err := loadSeccomp(path, tt.capabilities)
+		if err != nil {
+			t.Fatalf("loadSeccomp(%q) error = %v", tt.capabilities, err)
+		}
+		if got := seccompNames(seccomp); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("loadSeccomp(%q) syscalls = %q, want %q", tt.capabilities, got, tt.want)
+		}
+		arches := []specs.Arch{specs.ArchX86_64, specs.ArchX86}
+		if !reflect.DeepEqual(seccomp.Architectures, arches) {
+			t.Errorf("loadSeccomp(%q) architectures = %q, want %q", tt.capabilities, seccomp.Architectures, arches)
+		}
+	}
+
+	seccomp, err := loadSeccomp(SeccompUnconfined, nil)
+	if err != nil || seccomp != nil {
+		t.Errorf("loadSeccomp(%s) = %v, error = %v", SeccompUnconfined, seccomp, err)
+	}
+}
+
+func TestLoadSeccompErrors(t *testing.T) {
+	tests := []string{
+		`{"defaultAction": "SCMP_ACT_ERRNO", "syscalls": [`,
+		`{"defaultAction": "SCMP_ACT_BOGUS", "syscalls": []}`,
+		`{"defaultAction": "SCMP_ACT_ERRNO", "syscalls": [{"action": "SCMP_ACT_ALLOW"}]}`,
+		`{"defaultAction": "SCMP_ACT_ERRNO", "syscalls": [{"names": ["read"], "action": "SCMP_ACT_BOGUS"}]}`,
+		`{"defaultAction": "SCMP_ACT_ERRNO", "syscalls": [{"names": ["read"], "action": "SCMP_ACT_ALLOW", "args": [{"index": 0, "value": 1, "op": "SCMP_CMP_BOGUS"}]}]}`,
+		`{"defaultAction": "SCMP_ACT_ERRNO", "syscalls": [{"names": ["read"], "action": "SCMP_ACT_ALLOW", "includes": {"minKernel": "latest"}}]}`,
+	}
+
+	for _, data := range tests {
+		_, err := loadSeccomp(writeSeccomp(t, data), nil)
+		if !errors.Is(err, ErrInvalidSeccomp) {
+			t.Errorf("loadSeccomp(%s) error = %v, want %v", data, err, ErrInvalidSeccomp)
+		}
+	}
+}
+
+func TestSeccompFilterMatches(t *testing.T) {
+	tests := []struct {
+		filter       seccompFilter
+		capabilities []string
+		all, want    bool
+	}{
+		{seccompFilter{}, nil, true, true},
+		{seccompFilter{}, nil, false, false},
+		{seccompFilter{Arches: []string{"amd64"}}, nil, true, true},
+		{seccompFilter{Arches: []string{"arm64"}}, nil, true, false},
+		{seccompFilter{Caps: []string{"CAP_KILL"}}, []string{"CAP_KILL"}, true, true},
+		{seccompFilter{Caps: []string{"CAP_KILL"}}, []string{}, true, false},
+		{seccompFilter{Caps: []string{"CAP_KILL"}}, nil, true, true},
+		{seccompFilter{Caps: []string{"CAP_KILL", "CAP_CHOWN"}}, []string{"CAP_KILL"}, true, false},
+		{seccompFilter{Caps: []string{"CAP_KILL", "CAP_CHOWN"}}, []string{"CAP_KILL"}, false, true},
+		{seccompFilter{Caps: []string{"CAP_SYS_ADMIN"}, Arches: []string{"arm64"}}, []string{}, false, false},
+		{seccompFilter{MinKernel: "1.0"}, nil, true, true},
+		{seccompFilter{MinKernel: "999.0"}, nil, false, false},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.filter.matches(tt.capabilities, tt.all)
+		if err != nil {
+			t.Errorf("%+v.matches(%q, %t) error = %v", tt.filter, tt.capabilities, tt.all, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%+v.matches(%q, %t) = %t, want %t", tt.filter, tt.capabilities, tt.all, got, tt.want)
+		}
+	}
+}
+
+func TestContainerSeccomp(t *testing.T) {
+	seccomp, err := containerSeccomp(&Options{})
+	if err != nil {
+		t.Fatalf("default profile error = %v", err)
+	}
+	names := seccompNames(seccomp)
+	for _, name := range []string{"read", "execve", "chown", "clone", "unshare"} {
+		if !slices.Contains(names, name) {
+			t.Errorf("default profile does not allow %s", name)
+		}
+	}
+	for _, name := range []string{"mount", "reboot", "kexec_load"} {
+		if slices.Contains(names, name) {
+			t.Errorf("default profile allows %s", name)
+		}
+	}
+
+	seccomp, err = containerSeccomp(&Options{SeccompLegacy: true})
+	if err != nil || seccomp == nil {
+		t.Errorf("legacy profile = %v, error = %v", seccomp, err)
+	}
+	seccomp, err = containerSeccomp(&Options{Seccomp: SeccompUnconfined})
+	if err != nil || seccomp != nil {
+		t.Errorf("unconfined profile = %v, error = %v", seccomp, err)
+	}
+	seccomp, err = containerSeccomp(&Options{SeccompLearn: "learned.json"})
+	if err != nil || seccomp != nil {
+		t.Errorf("learning profile = %v, error = %v", seccomp, err)
+	}
+
+	for _, opts := range []*Options{
+		{SeccompLegacy: true, Seccomp: SeccompUnconfined},
+		{SeccompLearn: "learned.json", Seccomp: SeccompUnconfined},
+		{SeccompLearn: "learned.json", SeccompLegacy: true},
+	} {
+		_, err = containerSeccomp(opts)
+		if !errors.Is(err, ErrInvalidSeccomp) {
+			t.Errorf("containerSeccomp(%+v) error = %v, want %v", opts, err, ErrInvalidSeccomp)
+		}
+	}
+}
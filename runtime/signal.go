@@ -0,0 +1,37 @@
+package runtime
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+)
+
+// forwardSignals forwards the signals meant to stop or control the caller to
+// the process with the given PID, so that the caller keeps waiting for it.
+// The returned function stops forwarding.
+func forwardSignals(pid uintptr) func() {
+	signals := make(chan os.Signal, 8)
+	signal.Notify(signals,
+		syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP,
+		syscall.SIGQUIT, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	done := make(chan struct{})
+	go func() {
+		for sig := range signals {
+			logrus.Debugf("Forwarding signal %s to PID %d", sig, pid)
+			err := syscall.Kill(int(pid), sig.(syscall.Signal))
+			if err != nil {
+				logrus.Debugf("Forwarding signal failed: %s", err)
+			}
+		}
+		close(done)
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(signals)
+		<-done
+	}
+}
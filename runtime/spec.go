@@ -0,0 +1,254 @@
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	specConfigFile     = "config.json"
+	processFileLimit   = 64
+	processDefaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+)
+
+// NewFromBundle creates a new container from the OCI bundle at the given path.
+func NewFromBundle(id string, bundle string) (*Runtime, error) {
+	validID := regexp.MustCompile(`^[\w+\-.]+$`)
+	if !validID.MatchString(id) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidID, id)
+	}
+
+	err := checkPlatform()
+	if err != nil {
+		return nil, err
+	}
+
+	bundle, err = filepath.Abs(bundle)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := loadSpec(filepath.Join(bundle, specConfigFile))
+	if err != nil {
+		return nil, err
+	}
+	err = resolveSpec(spec, bundle)
+	if err != nil {
+		return nil, err
+	}
+	logrus.Debugf("Loading bundle %s with OCI version %s", bundle, spec.Version)
+
+	return newRuntime(id, bundle, spec)
+}
+
+// loadSpec reads an OCI runtime configuration from the given file.
+func loadSpec(path string) (*specs.Spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := &specs.Spec{}
+	err = json.Unmarshal(data, spec)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSpec, err)
+	}
+
+	return spec, nil
+}
+
+// resolveSpec validates the spec and makes its paths independent of the
+// bundle directory.
+func resolveSpec(spec *specs.Spec, bundle string) error {
+	switch {
+	case spec.Process == nil:
+		return fmt.Errorf("%w: process is not set", ErrInvalidSpec)
+	case len(spec.Process.Args) == 0:
+		return fmt.Errorf("%w: process.args is empty", ErrInvalidSpec)
+	case spec.Root == nil || spec.Root.Path == "":
+		return fmt.Errorf("%w: root.path is not set", ErrInvalidSpec)
+	case spec.Linux == nil:
+		return fmt.Errorf("%w: linux is not set", ErrInvalidSpec)
+	case !hasNamespace(spec.Linux.Namespaces, specs.MountNamespace):
+		return fmt.Errorf("%w: a mount namespace is required", ErrInvalidSpec)
+	}
+	if spec.Process.Terminal {
+		logrus.Warnf("Terminal is not supported for bundles, the container will use the caller's stdio")
+		spec.Process.Terminal = false
+	}
+	if spec.Process.Cwd == "" {
+		spec.Process.Cwd = "/"
+	}
+
+	if !filepath.IsAbs(spec.Root.Path) {
+		spec.Root.Path = filepath.Join(bundle, spec.Root.Path)
+	}
+	for i := range spec.Mounts {
+		m := &spec.Mounts[i]
+		if !filepath.IsAbs(m.Destination) {
+			return fmt.Errorf("%w: mount destination %q is not absolute", ErrInvalidSpec, m.Destination)
+		}
+		if isBindMount(m) && !filepath.IsAbs(m.Source) {
+			m.Source = filepath.Join(bundle, m.Source)
+		}
+	}
+
+	_, err := cloneFlags(spec.Linux.Namespaces)
+	return err
+}
+
+// defaultSpec creates the spec used by containers started from the command line.
+func defaultSpec(argv []string, opts *Options) *specs.Spec {
+	mounts := systemMounts(opts)
+	for _, v := range opts.Volumes {
+		mounts = append(mounts, specs.Mount{
+			Destination: "/" + v.Target,
+			Type:        "bind",
+			Source:      v.Source,
+			Options:     v.mountOptions(),
+		})
+	}
+
+	annotations := map[string]string{
+		annotationNetwork: opts.Network,
+		annotationOverlay: "true",
+	}
+	if opts.Remove {
+		annotations[annotationRemove] = "true"
+	}
+	if opts.Init {
+		annotations[annotationInit] = "true"
+	}
+	if opts.SeccompLearn != "" {
+		annotations[annotationSeccompLearn] = opts.SeccompLearn
+	}
+	if len(opts.Ports) > 0 {
+		data, err := json.Marshal(opts.Ports)
+		if err == nil {
+			annotations[annotationPorts] = string(data)
+		}
+	}
+	cwd := "/"
+	if opts.Workdir != "" {
+		cwd = opts.Workdir
+	}
+	namespaces := []specs.LinuxNamespace{
+		{Type: specs.MountNamespace},
+		{Type: specs.CgroupNamespace},
+		{Type: specs.PIDNamespace},
+		{Type: specs.IPCNamespace},
+		{Type: specs.UTSNamespace},
+		{Type: specs.UserNamespace},
+	}
+	if opts.Network != NetworkHost {
+		namespaces = append(namespaces, specs.LinuxNamespace{Type: specs.NetworkNamespace})
+	}
+	devices, rules := defaultDevices()
+	resources := opts.Resources.linuxResources()
+	resources.Devices = rules
+
+	return &specs.Spec{
+		Version:     specs.Version,
+		Annotations: annotations,
+		Process: &specs.Process{
+			User: specs.User{UID: uint32(opts.UID), GID: uint32(opts.UID)},
+			Args: append(append([]string{}, opts.Entrypoint...), argv...),
+			Env:  mergeEnv(os.Environ(), opts.Env),
+			Cwd:  cwd,
+			Rlimits: []specs.POSIXRlimit{
+				{Type: "RLIMIT_NOFILE", Hard: processFileLimit, Soft: processFileLimit},
+			},
+		},
+		Root:   &specs.Root{Path: opts.Root},
+		Mounts: mounts,
+		Linux: &specs.Linux{
+			Namespaces: namespaces,
+			Devices:    devices,
+			Resources:  resources,
+		},
+	}
+}
+
+// mergeEnv returns the environment base with the variables of overrides
+// replacing the ones of the same name. A variable given without a value
+// takes the value it has in the caller's environment, if any.
+func mergeEnv(base []string, overrides []string) []string {
+	env := append([]string{}, base...)
+	for _, kv := range overrides {
+		name, _, ok := strings.Cut(kv, "=")
+		if !ok {
+			value, found := os.LookupEnv(name)
+			if !found {
+				continue
+			}
+			kv = name + "=" + value
+		}
+
+		replaced := false
+		for i, existing := range env {
+			if strings.HasPrefix(existing, name+"=") {
+				env[i], replaced = kv, true
+			}
+		}
+		if !replaced {
+			env = append(env, kv)
+		}
+	}
+
+	return env
+}
+
+// hasEnv reports whether the environment sets the named variable.
+func hasEnv(env []string, name string) bool {
+	for _, kv := range env {
+		if strings.HasPrefix(kv, name+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// cloneFlags returns the clone flags creating the given namespaces. The user
+// namespace is unshared later by the child and has no flag here.
+func cloneFlags(namespaces []specs.LinuxNamespace) (uintptr, error) {
+	var flags = map[specs.LinuxNamespaceType]uintptr{
+		specs.MountNamespace:   syscall.CLONE_NEWNS,
+		specs.CgroupNamespace:  syscall.CLONE_NEWCGROUP,
+		specs.PIDNamespace:     syscall.CLONE_NEWPID,
+		specs.IPCNamespace:     syscall.CLONE_NEWIPC,
+		specs.NetworkNamespace: syscall.CLONE_NEWNET,
+		specs.UTSNamespace:     syscall.CLONE_NEWUTS,
+		specs.UserNamespace:    0,
+	}
+
+	var result uintptr
+	for _, ns := range namespaces {
+		flag, ok := flags[ns.Type]
+		if !ok {
+			return 0, fmt.Errorf("%w: %s", ErrUnsupportedNamespace, ns.Type)
+		}
+		if ns.Path != "" {
+			return 0, fmt.Errorf("%w: joining %s namespace at %s", ErrUnsupportedNamespace, ns.Type, ns.Path)
+		}
+		result |= flag
+	}
+
+	return result, nil
+}
+
+// hasNamespace reports whether the given namespace type is requested.
+func hasNamespace(namespaces []specs.LinuxNamespace, nsType specs.LinuxNamespaceType) bool {
+	for _, ns := range namespaces {
+		if ns.Type == nsType {
+			return true
+		}
+	}
+	return false
+}
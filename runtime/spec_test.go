@@ -0,0 +1,36 @@
+package runtime
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeEnv(t *testing.T) {
+	t.Setenv("GOPHINATOR_TEST_HOST", "host")
+
+	tests := []struct {
+		base      []string
+		overrides []string
+		want      []string
+	}{
+		{nil, nil, []string{}},
+		{[]string{"A=1"}, nil, []string{"A=1"}},
+		{[]string{"A=1", "B=2"}, []string{"B=3"}, []string{"A=1", "B=3"}},
+		{[]string{"A=1"}, []string{"B=2", "A="}, []string{"A=", "B=2"}},
+		{[]string{"AB=1"}, []string{"A=2"}, []string{"AB=1", "A=2"}},
+		{[]string{"A=1"}, []string{"A=x=y"}, []string{"A=x=y"}},
+		{[]string{"A=1"}, []string{"GOPHINATOR_TEST_HOST"}, []string{"A=1", "GOPHINATOR_TEST_HOST=host"}},
+		{[]string{"A=1"}, []string{"GOPHINATOR_TEST_UNSET"}, []string{"A=1"}},
+	}
+
+	for _, tt := range tests {
+		base := append([]string(nil), tt.base...)
+		got := mergeEnv(tt.base, tt.overrides)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("mergeEnv(%q, %q) = %q, want %q", tt.base, tt.overrides, got, tt.want)
+		}
+		if !reflect.DeepEqual(tt.base, base) {
+			t.Errorf("mergeEnv(%q, %q) modified its base", base, tt.overrides)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package runtime
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	stateDir      = "/run/gophinator"
+	stateFile     = "state.json"
+	stateExecFifo = "exec.fifo"
+	stateLogFile  = "container.log"
+)
+
+// State is the persisted state of a container.
+type State struct {
+	specs.State
+	Hostname    string        `json:"hostname"`
+	UUID        string        `json:"uuid"`
+	Root        string        `json:"root"`
+	Image       string        `json:"image,omitempty"`
+	UpperDir    string        `json:"upperDir,omitempty"`
+	Volumes     []VolumePair  `json:"volumes"`
+	Network     string        `json:"network"`
+	IPAddress   string        `json:"ipAddress,omitempty"`
+	IPv6Address string        `json:"ipv6Address,omitempty"`
+	Ports       []PortMapping `json:"ports,omitempty"`
+	Created     time.Time     `json:"created"`
+	Started     time.Time     `json:"started"`
+	Finished    time.Time     `json:"finished"`
+	ExitCode    int           `json:"exitCode"`
+}
+
+// statePath returns the path of a file in the state directory of a container.
+func statePath(id string, name string) string {
+	return filepath.Join(stateDir, id, name)
+}
+
+// ReadState reads the state of the container with the given ID and
+// reconciles it with the container process.
+func ReadState(id string) (*State, error) {
+	st, err := readState(id)
+	if err != nil {
+		return nil, err
+	}
+
+	switch st.Status {
+	case specs.StateCreated, specs.StateRunning:
+		err = syscall.Kill(st.Pid, 0)
+		if errors.Is(err, syscall.ESRCH) {
+			st.Status = specs.StateStopped
+			break
+		}
+		_, err = os.Stat(statePath(id, stateExecFifo))
+		if st.Status == specs.StateCreated && errors.Is(err, os.ErrNotExist) {
+			st.Status = specs.StateRunning
+		}
+	case specs.StateCreating, specs.StateStopped:
+	}
+
+	return st, nil
+}
+
+// readState reads the state of a container as it was last written.
+func readState(id string) (*State, error) {
+	data, err := os.ReadFile(statePath(id, stateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrContainerNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	st := &State{}
+	err = json.Unmarshal(data, st)
+	if err != nil {
+		return nil, err
+	}
+
+	return st, nil
+}
+
+// writeState atomically replaces the state of a container.
+func writeState(st *State) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+
+	path := statePath(st.ID, stateFile)
+	err = os.WriteFile(path+".tmp", data, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// markCreated records that the container process is waiting to be started.
+func markCreated(st *State, r *Runtime) error {
+	st.Status = specs.StateCreated
+	st.Pid = int(r.pid)
+	if r.network != nil {
+		st.IPAddress = r.network.ipv4.String()
+		st.IPv6Address = r.network.ipv6.String()
+	}
+	return writeState(st)
+}
+
+// markRunning records that the container process executes the user command.
+func markRunning(st *State) {
+	st.Status = specs.StateRunning
+	st.Started = time.Now()
+	err := writeState(st)
+	if err != nil {
+		logrus.Errorf("Fail to write state: %s", err)
+	}
+}
+
+// markStopped records that the container process exited with the given code.
+func markStopped(st *State, code int) {
+	st.Status = specs.StateStopped
+	st.Finished = time.Now()
+	st.ExitCode = code
+	err := writeState(st)
+	if err != nil {
+		logrus.Errorf("Fail to write state: %s", err)
+	}
+}
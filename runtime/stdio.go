@@ -0,0 +1,98 @@
+package runtime
+
+import (
+	"io"
+	"os"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// stdio is a set of pipes replacing the standard streams of a container.
+type stdio struct {
+	// child holds the ends the container uses as stdin, stdout and stderr.
+	child [3]*os.File
+	// parent holds the ends relayed to the standard streams of the caller.
+	parent [3]*os.File
+}
+
+// newPipes creates the pipes for the standard streams of a container.
+func newPipes() (*stdio, error) {
+	s := &stdio{}
+	for i := range s.child {
+		r, w, err := os.Pipe()
+		if err != nil {
+			s.close()
+			return nil, err
+		}
+		if i == 0 {
+			s.child[i], s.parent[i] = r, w
+		} else {
+			s.child[i], s.parent[i] = w, r
+		}
+	}
+
+	return s, nil
+}
+
+// setup makes the child ends the standard streams of the calling process.
+func (s *stdio) setup() error {
+	for i, f := range s.child {
+		err := unix.Dup2(int(f.Fd()), i)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// closeChild closes the child ends once the container holds its own copies,
+// so that the parent sees EOF when the container exits.
+func (s *stdio) closeChild() {
+	for i, f := range s.child {
+		if f != nil {
+			f.Close()
+			s.child[i] = nil
+		}
+	}
+}
+
+// close closes all the pipes.
+func (s *stdio) close() {
+	s.closeChild()
+	for i, f := range s.parent {
+		if f != nil {
+			f.Close()
+			s.parent[i] = nil
+		}
+	}
+}
+
+// attachPipes relays the standard streams of the caller to the pipes,
+// closing the container's stdin when the caller's stdin reaches EOF. The
+// returned function waits for stdout and stderr to be drained.
+func attachPipes(s *stdio) func() {
+	go func(stdin *os.File) {
+		_, err := io.Copy(stdin, os.Stdin)
+		if err != nil {
+			logrus.Debugf("Relaying stdin failed: %s", err)
+		}
+		stdin.Close()
+	}(s.parent[0])
+
+	var drained sync.WaitGroup
+	for i, dst := range []*os.File{os.Stdout, os.Stderr} {
+		drained.Add(1)
+		go func(dst *os.File, src *os.File) {
+			defer drained.Done()
+			_, err := io.Copy(dst, src)
+			if err != nil {
+				logrus.Debugf("Relaying output failed: %s", err)
+			}
+		}(dst, s.parent[i+1])
+	}
+
+	return drained.Wait
+}
@@ -0,0 +1,145 @@
+package runtime
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// newConsole allocates a pseudo-terminal and returns its master and slave.
+func newConsole() (*os.File, *os.File, error) {
+	ptmx, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = unix.IoctlSetPointerInt(int(ptmx.Fd()), unix.TIOCSPTLCK, 0)
+	if err != nil {
+		ptmx.Close()
+		return nil, nil, err
+	}
+	n, err := unix.IoctlGetUint32(int(ptmx.Fd()), unix.TIOCGPTN)
+	if err != nil {
+		ptmx.Close()
+		return nil, nil, err
+	}
+	pts, err := os.OpenFile("/dev/pts/"+strconv.Itoa(int(n)), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		ptmx.Close()
+		return nil, nil, err
+	}
+
+	return ptmx, pts, nil
+}
+
+// setupConsole makes the pseudo-terminal slave the controlling terminal and
+// the standard streams of the calling process.
+func setupConsole(pts *os.File) error {
+	_, err := unix.Setsid()
+	if err != nil {
+		return err
+	}
+	fd := int(pts.Fd())
+	err = unix.IoctlSetInt(fd, unix.TIOCSCTTY, 0)
+	if err != nil {
+		return err
+	}
+	for _, stdio := range []int{0, 1, 2} {
+		err = unix.Dup2(fd, stdio)
+		if err != nil {
+			return err
+		}
+	}
+
+	return pts.Close()
+}
+
+// attachConsole relays the standard streams to the pseudo-terminal master,
+// with the host terminal in raw mode and window resizes forwarded. The
+// returned function waits for the output to be drained and restores the
+// host terminal.
+func attachConsole(ptmx *os.File) (func(), error) {
+	stdin := int(os.Stdin.Fd())
+	saved, err := unix.IoctlGetTermios(stdin, unix.TCGETS)
+	interactive := err == nil
+	if interactive {
+		resizeConsole(ptmx, stdin)
+		err = setRawTerminal(stdin, saved)
+		if err != nil {
+			return nil, err
+		}
+		logrus.Debugf("Switching host terminal to raw mode")
+	}
+
+	resizes := make(chan os.Signal, 1)
+	if interactive {
+		signal.Notify(resizes, syscall.SIGWINCH)
+	}
+	go func() {
+		for range resizes {
+			resizeConsole(ptmx, stdin)
+		}
+	}()
+
+	go io.Copy(ptmx, os.Stdin)
+	drained := make(chan struct{})
+	go func() {
+		_, err := io.Copy(os.Stdout, ptmx)
+		if err != nil && !errors.Is(err, syscall.EIO) {
+			logrus.Debugf("Relaying console output failed: %s", err)
+		}
+		close(drained)
+	}()
+
+	return func() {
+		<-drained
+		signal.Stop(resizes)
+		close(resizes)
+		if interactive {
+			err := unix.IoctlSetTermios(stdin, unix.TCSETS, saved)
+			if err != nil {
+				logrus.Errorf("Fail to restore host terminal: %s", err)
+			}
+		}
+	}, nil
+}
+
+// isTerminal reports whether the file is a terminal.
+func isTerminal(f *os.File) bool {
+	_, err := unix.IoctlGetTermios(int(f.Fd()), unix.TCGETS)
+	return err == nil
+}
+
+// setRawTerminal puts the terminal into raw mode, the way cfmakeraw does.
+func setRawTerminal(fd int, saved *unix.Termios) error {
+	raw := *saved
+	raw.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	raw.Oflag &^= unix.OPOST
+	raw.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	raw.Cflag &^= unix.CSIZE | unix.PARENB
+	raw.Cflag |= unix.CS8
+	raw.Cc[unix.VMIN] = 1
+	raw.Cc[unix.VTIME] = 0
+
+	return unix.IoctlSetTermios(fd, unix.TCSETS, &raw)
+}
+
+// resizeConsole copies the window size of the host terminal to the
+// pseudo-terminal.
+func resizeConsole(ptmx *os.File, fd int) {
+	size, err := unix.IoctlGetWinsize(fd, unix.TIOCGWINSZ)
+	if err != nil {
+		logrus.Debugf("Getting host window size failed: %s", err)
+		return
+	}
+	err = unix.IoctlSetWinsize(int(ptmx.Fd()), unix.TIOCSWINSZ, size)
+	if err != nil {
+		logrus.Debugf("Resizing console failed: %s", err)
+	}
+}
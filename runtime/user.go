@@ -0,0 +1,107 @@
+package runtime
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	userPasswdFile = "/etc/passwd"
+	userGroupFile  = "/etc/group"
+)
+
+// resolveUser returns the user and group given as user[:group], either by
+// name or by ID, looking names up in the root filesystem made of the given
+// layers, the topmost first. A user without a group gets its primary group,
+// or the group with the same ID when it has no entry.
+func resolveUser(layers []string, user string) (specs.User, error) {
+	name, group, hasGroup := strings.Cut(user, ":")
+	uid, err := strconv.ParseUint(name, 10, 32)
+	gid := uid
+	if err != nil {
+		entry, err := lookupEntry(layers, userPasswdFile, name)
+		if err != nil {
+			return specs.User{}, err
+		}
+		uid, gid = entry[0], entry[1]
+	} else if entry, err := lookupEntry(layers, userPasswdFile, name); err == nil {
+		gid = entry[1]
+	}
+
+	if hasGroup {
+		gid, err = strconv.ParseUint(group, 10, 32)
+		if err != nil {
+			entry, err := lookupEntry(layers, userGroupFile, group)
+			if err != nil {
+				return specs.User{}, err
+			}
+			gid = entry[0]
+		}
+	}
+
+	return specs.User{UID: uint32(uid), GID: uint32(gid)}, nil
+}
+
+// lookupEntry returns the IDs following the password field of the entry of
+// a passwd or group file whose name or first ID matches key.
+func lookupEntry(layers []string, file string, key string) ([]uint64, error) {
+	data, err := readRootFile(layers, file)
+	if err != nil {
+		return nil, fmt.Errorf("looking up %s: %w", key, err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) < 4 || (fields[0] != key && fields[2] != key) {
+			continue
+		}
+		ids := []uint64{}
+		for _, field := range fields[2:4] {
+			id, err := strconv.ParseUint(field, 10, 32)
+			if err != nil {
+				break
+			}
+			ids = append(ids, id)
+		}
+		if len(ids) == 2 || (file == userGroupFile && len(ids) == 1) {
+			return ids, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: no entry for %s in %s", ErrInvalidSpec, key, file)
+}
+
+// readRootFile reads a file of the root filesystem made of the given
+// layers, the topmost first, honoring the whiteouts of overlayfs.
+func readRootFile(layers []string, name string) ([]byte, error) {
+	for _, layer := range layers {
+		p := filepath.Join(layer, name)
+		info, err := os.Lstat(p)
+		if err == nil {
+			if info.Mode()&os.ModeCharDevice != 0 {
+				break
+			}
+			return os.ReadFile(p)
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		opaque := make([]byte, 1)
+		n, err := unix.Lgetxattr(filepath.Dir(p), overlayOpaqueXattr, opaque)
+		if err == nil && n == 1 && opaque[0] == 'y' {
+			break
+		}
+	}
+
+	return nil, fmt.Errorf("%s: %w", name, os.ErrNotExist)
+}
@@ -0,0 +1,116 @@
+package runtime
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+// writeRootFile writes a file of a layer, creating its directories.
+func writeRootFile(t *testing.T, layer string, name string, data string) {
+	t.Helper()
+	p := filepath.Join(layer, name)
+	err := os.MkdirAll(filepath.Dir(p), 0755)
+	if err == nil {
+		err = os.WriteFile(p, []byte(data), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResolveUser(t *testing.T) {
+	layer := t.TempDir()
+	writeRootFile(t, layer, userPasswdFile, "root:x:0:0:root:/root:/bin/sh\n"+
+		"app:x:1000:1001:app:/home/app:/bin/sh\n"+
+		"broken:x:nope:1:::\n"+
+		"short:x:7\n")
+	writeRootFile(t, layer, userGroupFile, "root:x:0:\n"+
+		"app:x:1001:\n"+
+		"staff:x:50:app,root\n")
+
+	tests := []struct {
+		user string
+		want specs.User
+		err  bool
+	}{
+		{"root", specs.User{UID: 0, GID: 0}, false},
+		{"app", specs.User{UID: 1000, GID: 1001}, false},
+		{"1000", specs.User{UID: 1000, GID: 1001}, false},
+		{"2000", specs.User{UID: 2000, GID: 2000}, false},
+		{"app:staff", specs.User{UID: 1000, GID: 50}, false},
+		{"app:60", specs.User{UID: 1000, GID: 60}, false},
+		{"2000:staff", specs.User{UID: 2000, GID: 50}, false},
+		{"nobody", specs.User{}, true},
+		{"broken", specs.User{}, true},
+		{"short", specs.User{}, true},
+		{"app:nogroup", specs.User{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := resolveUser([]string{layer}, tt.user)
+		if tt.err {
+			if err == nil {
+				t.Errorf("resolveUser(%q) = %+v, want an error", tt.user, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("resolveUser(%q) error = %v", tt.user, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("resolveUser(%q) = %+v, want %+v", tt.user, got, tt.want)
+		}
+	}
+}
+
+func TestReadRootFile(t *testing.T) {
+	upper, lower := t.TempDir(), t.TempDir()
+	writeRootFile(t, lower, "/etc/passwd", "lower")
+	writeRootFile(t, lower, "/etc/group", "lower")
+	writeRootFile(t, lower, "/etc/hosts", "lower")
+	writeRootFile(t, lower, "/opt/app", "lower")
+	writeRootFile(t, upper, "/etc/hosts", "upper")
+	err := unix.Mknod(filepath.Join(upper, "/etc/group"), unix.S_IFCHR, 0)
+	if err != nil {
+		t.Skipf("creating whiteout: %s", err)
+	}
+	err = os.MkdirAll(filepath.Join(upper, "/opt"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = unix.Lsetxattr(filepath.Join(upper, "/opt"), overlayOpaqueXattr, []byte("y"), 0)
+	if err != nil {
+		t.Skipf("marking directory opaque: %s", err)
+	}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"/etc/passwd", "lower"},
+		{"/etc/hosts", "upper"},
+		{"/etc/group", ""},
+		{"/opt/app", ""},
+		{"/etc/shadow", ""},
+	}
+
+	for _, tt := range tests {
+		got, err := readRootFile([]string{upper, lower}, tt.name)
+		if tt.want == "" {
+			if !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("readRootFile(%q) = %q, %v, want %v", tt.name, got, err, os.ErrNotExist)
+			}
+			continue
+		}
+		if err != nil || string(got) != tt.want {
+			t.Errorf("readRootFile(%q) = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package runtime
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseVolume parses a volume in the form source:target[:options], where
+// options is a comma separated list of ro, rw, nosuid, nodev, noexec and one
+// of the shared, slave and private propagations, recursive or not.
+func ParseVolume(s string) (VolumePair, error) {
+	chunks := strings.Split(s, ":")
+	if len(chunks) < 2 || len(chunks) > 3 || chunks[0] == "" || chunks[1] == "" {
+		return VolumePair{}, fmt.Errorf("%w: %q", ErrInvalidVolume, s)
+	}
+	volume := VolumePair{Source: chunks[0], Target: strings.TrimPrefix(chunks[1], "/")}
+	if len(chunks) == 3 {
+		volume.Options = strings.Split(chunks[2], ",")
+	}
+
+	return volume, volume.validate()
+}
+
+// validate checks that the options of the volume are known and that at most
+// one propagation is given.
+func (v VolumePair) validate() error {
+	return validateOptions(v.Options, ErrInvalidVolume)
+}
+
+// validateOptions checks that mount options are known and that at most one
+// propagation is given, wrapping sentinel in the error.
+func validateOptions(options []string, sentinel error) error {
+	var known = map[string]bool{
+		"ro":       false,
+		"rw":       false,
+		"nosuid":   false,
+		"nodev":    false,
+		"noexec":   false,
+		"shared":   true,
+		"rshared":  true,
+		"slave":    true,
+		"rslave":   true,
+		"private":  true,
+		"rprivate": true,
+	}
+
+	propagations := 0
+	for _, option := range options {
+		propagation, ok := known[option]
+		if !ok {
+			return fmt.Errorf("%w: unknown option %q", sentinel, option)
+		}
+		if propagation {
+			propagations++
+		}
+	}
+	if propagations > 1 {
+		return fmt.Errorf("%w: more than one propagation in %q", sentinel, strings.Join(options, ","))
+	}
+
+	return nil
+}
+
+// mountOptions returns the options of the recursive bind mount of the
+// volume, which is private unless another propagation is given.
+func (v VolumePair) mountOptions() []string {
+	options := append([]string{"rbind"}, v.Options...)
+	for _, option := range v.Options {
+		if strings.HasSuffix(option, "shared") || strings.HasSuffix(option, "slave") || strings.HasSuffix(option, "private") {
+			return options
+		}
+	}
+	return append(options, "rprivate")
+}
@@ -0,0 +1,63 @@
+package runtime
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseVolume(t *testing.T) {
+	tests := []struct {
+		in   string
+		want VolumePair
+		err  error
+	}{
+		{"/src:/dst", VolumePair{Source: "/src", Target: "dst"}, nil},
+		{"/src:dst", VolumePair{Source: "/src", Target: "dst"}, nil},
+		{"/src:/dst:ro,nosuid", VolumePair{Source: "/src", Target: "dst", Options: []string{"ro", "nosuid"}}, nil},
+		{"/src:/dst:rslave", VolumePair{Source: "/src", Target: "dst", Options: []string{"rslave"}}, nil},
+		{"/src", VolumePair{}, ErrInvalidVolume},
+		{":/dst", VolumePair{}, ErrInvalidVolume},
+		{"/src:", VolumePair{}, ErrInvalidVolume},
+		{"/src:/dst:ro:rw", VolumePair{}, ErrInvalidVolume},
+		{"/src:/dst:bogus", VolumePair{}, ErrInvalidVolume},
+		{"/src:/dst:ro,", VolumePair{}, ErrInvalidVolume},
+		{"/src:/dst:shared,rslave", VolumePair{}, ErrInvalidVolume},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseVolume(tt.in)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("ParseVolume(%q) error = %v, want %v", tt.in, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseVolume(%q) error = %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseVolume(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestVolumeMountOptions(t *testing.T) {
+	tests := []struct {
+		options []string
+		want    []string
+	}{
+		{nil, []string{"rbind", "rprivate"}},
+		{[]string{"ro"}, []string{"rbind", "ro", "rprivate"}},
+		{[]string{"rshared"}, []string{"rbind", "rshared"}},
+		{[]string{"ro", "slave"}, []string{"rbind", "ro", "slave"}},
+	}
+
+	for _, tt := range tests {
+		got := VolumePair{Options: tt.options}.mountOptions()
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("mountOptions(%q) = %q, want %q", tt.options, got, tt.want)
+		}
+	}
+}